@@ -0,0 +1,122 @@
+package stalecucumber
+
+import (
+	"fmt"
+	"reflect"
+)
+
+/*
+This type maps Python classes to the Go types that instances of them
+are unpacked into and pickled from. Set it as "Classes" on the return
+value of UnpackInto and on a Pickler.
+
+When UnpackInto places a PythonObject into a field that is an
+interface, such as a Shape or an item of a []Event, it creates a value
+of the Go type registered for the class and unpacks the state of the
+object into it. The Go type must implement the interface.
+
+	classes := stalecucumber.NewClassRegistry()
+	classes.Register("shapes", "Circle", Circle{})
+	classes.Register("shapes", "Square", &Square{})
+
+	var dst []Shape
+	u := stalecucumber.UnpackInto(&dst)
+	u.Classes = classes
+	err := u.From(stalecucumber.Unpickle(reader))
+
+Registering a pointer, such as &Square{}, places a *Square in the
+interface instead of a Square. The Pickler writes a value of a
+registered type as an instance of its class, with its fields as the
+state of the instance.
+
+Only instances of new-style classes, which are pickled with NEWOBJ or
+by reducing copy_reg._reconstructor, are unpickled as a PythonObject.
+Instances of Python 2 old-style classes, which protocols 0 and 1 write
+with INST and OBJ, are not, so the registry has no effect on them.
+*/
+type ClassRegistry struct {
+	types   map[PythonGlobal]reflect.Type
+	classes map[reflect.Type]PythonGlobal
+}
+
+func NewClassRegistry() *ClassRegistry {
+	return &ClassRegistry{
+		types:   make(map[PythonGlobal]reflect.Type),
+		classes: make(map[reflect.Type]PythonGlobal),
+	}
+}
+
+/*
+Registers the type of v for the Python class name in module. It
+replaces any type already registered for the class.
+
+Register panics if v is not a struct or a pointer to a struct.
+*/
+func (cr *ClassRegistry) Register(module string, name string, v interface{}) {
+	t := reflect.TypeOf(v)
+	base := t
+	if base != nil && base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	if base == nil || base.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Cannot register %T as a Python class", v))
+	}
+
+	class := PythonGlobal{Module: module, Name: name}
+	cr.types[class] = t
+	cr.classes[base] = class
+}
+
+/*
+Returns the Go type registered for a Python class.
+*/
+func (cr *ClassRegistry) goType(class PythonGlobal) (reflect.Type, bool) {
+	if cr == nil {
+		return nil, false
+	}
+	t, ok := cr.types[class]
+	return t, ok
+}
+
+/*
+Returns the Python class registered for a struct type.
+*/
+func (cr *ClassRegistry) pythonClass(t reflect.Type) (PythonGlobal, bool) {
+	if cr == nil {
+		return PythonGlobal{}, false
+	}
+	class, ok := cr.classes[t]
+	return class, ok
+}
+
+/*
+Returns the attributes of a Python object as a dict. Objects of classes
+with __slots__ have a state that is a tuple of a dict, or None, and a
+dict of the slots. These are merged.
+*/
+func objectAttributes(object *PythonObject) (map[interface{}]interface{}, bool) {
+	switch state := object.State.(type) {
+	case nil, PickleNone:
+		return map[interface{}]interface{}{}, true
+	case map[interface{}]interface{}:
+		return state, true
+	case []interface{}:
+		if len(state) != 2 {
+			return nil, false
+		}
+		attributes := make(map[interface{}]interface{})
+		for _, part := range state {
+			switch part := part.(type) {
+			case PickleNone:
+			case map[interface{}]interface{}:
+				for k, v := range part {
+					attributes[k] = v
+				}
+			default:
+				return nil, false
+			}
+		}
+		return attributes, true
+	}
+	return nil, false
+}
@@ -0,0 +1,186 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testShape interface {
+	Area() float64
+}
+
+type testCircle struct {
+	Radius float64 `pickle:"radius"`
+}
+
+func (c testCircle) Area() float64 {
+	return math.Pi * c.Radius * c.Radius
+}
+
+type testSquare struct {
+	Side int64 `pickle:"side"`
+}
+
+func (s *testSquare) Area() float64 {
+	return float64(s.Side * s.Side)
+}
+
+func testShapes() *ClassRegistry {
+	classes := NewClassRegistry()
+	classes.Register("shapes", "Circle", testCircle{})
+	classes.Register("shapes", "Square", &testSquare{})
+	return classes
+}
+
+func TestUnpickleObjects(t *testing.T) {
+	const input = "\x80\x02]q\x00(cshapes\nCircle\nq\x01)\x81q\x02}q\x03X\x06\x00\x00\x00radiusq\x04G?\xf8\x00\x00\x00\x00\x00\x00sbcshapes\nSquare\nq\x05)\x81q\x06N}q\x07X\x04\x00\x00\x00sideq\x08K\x02s\x86q\tbh\x02e."
+
+	result, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	circle := &PythonObject{
+		Class: PythonGlobal{Module: "shapes", Name: "Circle"},
+		Args:  []interface{}{},
+		State: map[interface{}]interface{}{"radius": 1.5},
+	}
+	expect := []interface{}{
+		circle,
+		&PythonObject{
+			Class: PythonGlobal{Module: "shapes", Name: "Square"},
+			Args:  []interface{}{},
+			State: []interface{}{PickleNone{}, map[interface{}]interface{}{"side": int64(2)}},
+		},
+		circle,
+	}
+	if !reflect.DeepEqual(expect, result) {
+		t.Fatalf("Got %v expected %v", result, expect)
+	}
+
+	//Protocol 0 uses copy_reg._reconstructor
+	result, err = Unpickle(strings.NewReader("ccopy_reg\n_reconstructor\np0\n(cshapes\nCircle\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVradius\np6\nI3\nsb."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectObject := &PythonObject{
+		Class: PythonGlobal{Module: "shapes", Name: "Circle"},
+		State: map[interface{}]interface{}{"radius": int64(3)},
+	}
+	if !reflect.DeepEqual(expectObject, result) {
+		t.Fatalf("Got %v expected %v", result, expectObject)
+	}
+}
+
+func TestUnpackObjects(t *testing.T) {
+	const input = "\x80\x02]q\x00(cshapes\nCircle\nq\x01)\x81q\x02}q\x03X\x06\x00\x00\x00radiusq\x04G?\xf8\x00\x00\x00\x00\x00\x00sbcshapes\nSquare\nq\x05)\x81q\x06N}q\x07X\x04\x00\x00\x00sideq\x08K\x02s\x86q\tbh\x02e."
+
+	var dst []testShape
+	u := UnpackInto(&dst)
+	u.Classes = testShapes()
+	err := u.From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []testShape{testCircle{Radius: 1.5}, &testSquare{Side: 2}, testCircle{Radius: 1.5}}
+	if !reflect.DeepEqual(expect, dst) {
+		t.Fatalf("Got %v expected %v", dst, expect)
+	}
+
+	//A concrete type does not need to be registered
+	var circle testCircle
+	u = UnpackInto(&circle)
+	u.Coerce = CoerceIntFloat
+	err = u.From(Unpickle(strings.NewReader("ccopy_reg\n_reconstructor\np0\n(cshapes\nCircle\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVradius\np6\nI3\nsb.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if circle.Radius != 3 {
+		t.Fatalf("Got %v", circle)
+	}
+
+	//Classes that are not registered can not fill an interface
+	classes := NewClassRegistry()
+	classes.Register("shapes", "Circle", testCircle{})
+	u = UnpackInto(&dst)
+	u.Classes = classes
+	err = u.From(Unpickle(strings.NewReader(input)))
+	if !errors.Is(err, ErrTargetTypeMismatch) {
+		t.Fatalf("Got %v", err)
+	}
+	if ue, ok := err.(UnpackingError); !ok || ue.Path != "[1]" {
+		t.Fatalf("Got %#v", err)
+	}
+}
+
+func TestPickleObjects(t *testing.T) {
+	classes := testShapes()
+
+	buf := &bytes.Buffer{}
+	p := NewPickler(buf)
+	p.Classes = classes
+	src := []testShape{testCircle{Radius: 1.5}, &testSquare{Side: 2}}
+	_, err := p.Pickle(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Unpickle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{
+		&PythonObject{
+			Class: PythonGlobal{Module: "shapes", Name: "Circle"},
+			Args:  []interface{}{},
+			State: map[interface{}]interface{}{"radius": 1.5},
+		},
+		&PythonObject{
+			Class: PythonGlobal{Module: "shapes", Name: "Square"},
+			Args:  []interface{}{},
+			State: map[interface{}]interface{}{"side": int64(2)},
+		},
+	}
+	if !reflect.DeepEqual(expect, result) {
+		t.Fatalf("Got %v expected %v", result, expect)
+	}
+
+	var dst []testShape
+	u := UnpackInto(&dst)
+	u.Classes = classes
+	err = u.From(result, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("Got %v expected %v", dst, src)
+	}
+
+	//A PythonObject is pickled as it was unpickled
+	buf.Reset()
+	_, err = NewPickler(buf).Pickle(expect[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = Unpickle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect[0], result) {
+		t.Fatalf("Got %v expected %v", result, expect[0])
+	}
+}
+
+func TestRegisterNonStruct(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Register did not panic")
+		}
+	}()
+	NewClassRegistry().Register("shapes", "Count", 42)
+}
@@ -0,0 +1,70 @@
+/*
+Command pickledis prints a symbolic disassembly of pickled data, in the same
+format as Python's "python -m pickletools".
+
+Usage:
+
+	pickledis [file ...]
+
+With no arguments the pickle is read from standard input. When a pickle is
+malformed or truncated, the listing up to the failure is printed followed
+by the byte offset at which decoding stopped.
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hydrogen18/stalecucumber"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [file ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if flag.NArg() == 0 {
+		if err := dis(out, os.Stdin); err != nil {
+			fail(out, "<stdin>", err)
+		}
+		return
+	}
+
+	for i, name := range flag.Args() {
+		if flag.NArg() > 1 {
+			if i != 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%s:\n", name)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			fail(out, name, err)
+		}
+		err = dis(out, f)
+		f.Close()
+		if err != nil {
+			fail(out, name, err)
+		}
+	}
+}
+
+func dis(w io.Writer, r io.Reader) error {
+	return stalecucumber.Disassemble(w, bufio.NewReader(r))
+}
+
+func fail(out *bufio.Writer, name string, err error) {
+	out.Flush()
+	fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	os.Exit(1)
+}
@@ -0,0 +1,146 @@
+package stalecucumber
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+/*
+Controls the conversions UnpackInto makes between values of different
+kinds. Set it as "Coerce" on the return value of UnpackInto. The zero
+value only unpacks values into destinations of the same kind. The flags
+may be combined.
+
+CoerceIntFloat unpacks Python ints and longs into float fields and
+Python floats that are whole numbers, such as 3.0, into integer fields.
+
+CoerceBoolInt unpacks True and False into integer fields as 1 and 0, and
+the Python ints 1 and 0 into bool fields.
+
+CoerceStringNumber unpacks Python strings that hold a number, such as
+"42" or "1.5", into number fields, and numbers into string fields.
+
+A value that would lose precision or does not fit, such as 3.5 into an
+int or 2 into a bool, fails with ErrTargetTypeOverflow. A struct field
+is zeroed instead if "AllowMismatchedFields" is set.
+*/
+type Coercion uint
+
+const (
+	CoerceIntFloat Coercion = 1 << iota
+	CoerceBoolInt
+	CoerceStringNumber
+
+	CoerceAll = CoerceIntFloat | CoerceBoolInt | CoerceStringNumber
+)
+
+/*
+Converts a value that could not be unpacked into the destination to a
+value that can, according to the coercions that are set. Returns false
+if no coercion applies.
+*/
+func (u unpacker) coerce(srcI interface{}, dst reflect.Type) (interface{}, bool, error) {
+	isBig := dst == reflect.TypeOf(big.Int{})
+
+	switch s := srcI.(type) {
+	case int64:
+		return u.coerce(big.NewInt(s), dst)
+	case *big.Int:
+		switch {
+		case u.Coerce&CoerceIntFloat != 0 && isFloatKind(dst.Kind()):
+			f := new(big.Float).SetInt(s)
+			var acc big.Accuracy
+			if dst.Kind() == reflect.Float32 {
+				_, acc = f.Float32()
+			} else {
+				_, acc = f.Float64()
+			}
+			if acc != big.Exact {
+				return nil, true, ErrTargetTypeOverflow
+			}
+			v, _ := f.Float64()
+			return v, true, nil
+		case u.Coerce&CoerceBoolInt != 0 && dst.Kind() == reflect.Bool:
+			if !s.IsInt64() || (s.Int64() != 0 && s.Int64() != 1) {
+				return nil, true, ErrTargetTypeOverflow
+			}
+			return s.Int64() == 1, true, nil
+		case u.Coerce&CoerceStringNumber != 0 && dst.Kind() == reflect.String:
+			return s.String(), true, nil
+		}
+	case float64:
+		switch {
+		case u.Coerce&CoerceIntFloat != 0 && (isIntKind(dst.Kind()) || isBig):
+			if math.IsInf(s, 0) || math.IsNaN(s) || s != math.Trunc(s) {
+				return nil, true, ErrTargetTypeOverflow
+			}
+			n, _ := new(big.Float).SetFloat64(s).Int(nil)
+			if n.IsInt64() {
+				return n.Int64(), true, nil
+			}
+			if !isBig {
+				return nil, true, ErrTargetTypeOverflow
+			}
+			return n, true, nil
+		case u.Coerce&CoerceStringNumber != 0 && dst.Kind() == reflect.String:
+			return strconv.FormatFloat(s, 'g', -1, 64), true, nil
+		}
+	case bool:
+		if u.Coerce&CoerceBoolInt != 0 && (isIntKind(dst.Kind()) || isBig) {
+			if s {
+				return int64(1), true, nil
+			}
+			return int64(0), true, nil
+		}
+	case PickleBytes:
+		return u.coerce(string(s), dst)
+	case string:
+		if u.Coerce&CoerceStringNumber == 0 {
+			break
+		}
+		switch {
+		case isIntKind(dst.Kind()) || isBig:
+			if n, ok := new(big.Int).SetString(s, 10); ok {
+				if n.IsInt64() {
+					return n.Int64(), true, nil
+				}
+				if !isBig {
+					return nil, true, ErrTargetTypeOverflow
+				}
+				return n, true, nil
+			}
+			//Text such as "3.0" is a float, which is unpacked
+			//only if CoerceIntFloat is also set
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, true, nil
+			}
+			return nil, true, ErrTargetTypeMismatch
+		case isFloatKind(dst.Kind()):
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+					return nil, true, ErrTargetTypeOverflow
+				}
+				return nil, true, ErrTargetTypeMismatch
+			}
+			return f, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
@@ -0,0 +1,299 @@
+package stalecucumber
+
+import (
+	"fmt"
+)
+
+/*
+Creates the containers that make up the result of unpickling. Supplying
+a ContainerBuilder to an Unpickler allows Python lists, dicts, tuples and
+sets to be built as any Go type, such as an ordered map, without
+converting the result afterwards.
+
+NewList and NewDict create empty containers. Append adds items to a
+list and SetItem adds a single key and value to a dict. Both return the
+container, which may be a new value. The new value replaces the old one
+wherever the pickle refers to the container later on, but not where the
+container was already placed inside another container. Builders that
+modify containers in place and return the same value never have this
+problem.
+
+NewTuple creates a tuple from all of its items, since tuples can not be
+modified in Python. Tuples are only created once they are placed inside
+another container or are the result, so the arguments of a call to a
+Python global are never passed to NewTuple. NewSet creates a set or a
+frozenset in the same way.
+
+Finish is called once with the result of unpickling and returns the
+value returned from Unpickle.
+
+Every item passed to a ContainerBuilder has already been built by it.
+*/
+type ContainerBuilder interface {
+	NewList() (interface{}, error)
+	Append(list interface{}, items []interface{}) (interface{}, error)
+	NewDict() (interface{}, error)
+	SetItem(dict interface{}, key interface{}, value interface{}) (interface{}, error)
+	NewTuple(items []interface{}) (interface{}, error)
+	NewSet(items []interface{}, frozen bool) (interface{}, error)
+	Finish(result interface{}) (interface{}, error)
+}
+
+/*
+A ContainerBuilder that creates the same types as Unpickle. It can be
+embedded into another type to replace only some of the containers.
+*/
+type DefaultContainerBuilder struct{}
+
+func (DefaultContainerBuilder) NewList() (interface{}, error) {
+	return make([]interface{}, 0), nil
+}
+
+func (DefaultContainerBuilder) Append(list interface{}, items []interface{}) (interface{}, error) {
+	l, ok := list.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Cannot append to %T", list)
+	}
+	return append(l, items...), nil
+}
+
+func (DefaultContainerBuilder) NewDict() (interface{}, error) {
+	return make(map[interface{}]interface{}), nil
+}
+
+func (DefaultContainerBuilder) SetItem(dict interface{}, key interface{}, value interface{}) (result interface{}, err error) {
+	d, ok := dict.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Cannot set item of %T", dict)
+	}
+
+	key, err = hashableKey(key)
+	if err != nil {
+		return nil, err
+	}
+	d[key] = value
+	return d, nil
+}
+
+func (DefaultContainerBuilder) NewTuple(items []interface{}) (interface{}, error) {
+	return items, nil
+}
+
+func (DefaultContainerBuilder) NewSet(items []interface{}, frozen bool) (result interface{}, err error) {
+	set := make(map[interface{}]bool, len(items))
+	for _, item := range items {
+		item, err = hashableKey(item)
+		if err != nil {
+			return nil, err
+		}
+		set[item] = true
+	}
+
+	if frozen {
+		return PickleFrozenSet(set), nil
+	}
+	return PickleSet(set), nil
+}
+
+func (DefaultContainerBuilder) Finish(result interface{}) (interface{}, error) {
+	return result, nil
+}
+
+const (
+	pendingList = iota
+	pendingDict
+	pendingTuple
+)
+
+/*
+A container created while a ContainerBuilder is in use. It is placed on
+the stack and in the memo in place of the container, so that every
+reference to the container sees the latest value returned by the
+builder. The items of lists and tuples are kept so that sets and calls
+to Python globals can be built from them.
+*/
+type pendingContainer struct {
+	kind  int
+	items []interface{}
+	value interface{}
+	built bool
+}
+
+/*
+Returns the value to place inside another container or return as the
+result. Tuples are built the first time this happens.
+*/
+func (pm *PickleMachine) embed(v interface{}) (interface{}, error) {
+	pc, ok := v.(*pendingContainer)
+	if !ok {
+		return v, nil
+	}
+
+	if !pc.built {
+		items, err := pm.embedAll(pc.items)
+		if err != nil {
+			return nil, err
+		}
+		pc.value, err = pm.builder.NewTuple(items)
+		if err != nil {
+			return nil, err
+		}
+		pc.built = true
+	}
+	return pc.value, nil
+}
+
+func (pm *PickleMachine) embedAll(items []interface{}) ([]interface{}, error) {
+	embedded := make([]interface{}, len(items))
+	for i, item := range items {
+		var err error
+		embedded[i], err = pm.embed(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return embedded, nil
+}
+
+/*
+Returns the items of a tuple, for use as the arguments of a call to a
+Python global.
+*/
+func (pm *PickleMachine) tupleItems(v interface{}) ([]interface{}, bool, error) {
+	if pm.builder == nil {
+		items, ok := v.([]interface{})
+		return items, ok, nil
+	}
+
+	pc, ok := v.(*pendingContainer)
+	if !ok || pc.kind != pendingTuple {
+		return nil, false, nil
+	}
+	items, err := pm.embedAll(pc.items)
+	return items, true, err
+}
+
+func (pm *PickleMachine) pushTuple(items []interface{}) {
+	if pm.builder == nil {
+		pm.push(items)
+		return
+	}
+	pm.push(&pendingContainer{kind: pendingTuple, items: items})
+}
+
+func (pm *PickleMachine) pushList(items []interface{}) error {
+	if pm.builder == nil {
+		pm.push(items)
+		return nil
+	}
+
+	list, err := pm.builder.NewList()
+	if err != nil {
+		return err
+	}
+	pc := &pendingContainer{kind: pendingList, value: list, built: true}
+	err = pm.appendWithBuilder(pc, items)
+	if err != nil {
+		return err
+	}
+	pm.push(pc)
+	return nil
+}
+
+func (pm *PickleMachine) appendWithBuilder(listI interface{}, items []interface{}) error {
+	pc, ok := listI.(*pendingContainer)
+	if !ok || pc.kind != pendingList {
+		return fmt.Errorf("Cannot append to (%T)%v", listI, listI)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	embedded, err := pm.embedAll(items)
+	if err != nil {
+		return err
+	}
+	pc.value, err = pm.builder.Append(pc.value, embedded)
+	if err != nil {
+		return err
+	}
+	pc.items = append(pc.items, embedded...)
+	return nil
+}
+
+/*
+Pushes a dict built from a slice of alternating keys and values.
+*/
+func (pm *PickleMachine) pushDictWithBuilder(keysAndValues []interface{}) error {
+	dict, err := pm.builder.NewDict()
+	if err != nil {
+		return err
+	}
+	pc := &pendingContainer{kind: pendingDict, value: dict, built: true}
+	err = pm.setItemsWithBuilder(pc, keysAndValues)
+	if err != nil {
+		return err
+	}
+	pm.push(pc)
+	return nil
+}
+
+func (pm *PickleMachine) setItemsWithBuilder(dictI interface{}, keysAndValues []interface{}) error {
+	pc, ok := dictI.(*pendingContainer)
+	if !ok || pc.kind != pendingDict {
+		return fmt.Errorf("Cannot set item of (%T)%v", dictI, dictI)
+	}
+	if len(keysAndValues)%2 != 0 {
+		return fmt.Errorf("Stack after mark contained an odd number of items, this is not valid")
+	}
+
+	embedded, err := pm.embedAll(keysAndValues)
+	if err != nil {
+		return err
+	}
+	for i := 0; i != len(embedded); i += 2 {
+		pc.value, err = pm.builder.SetItem(pc.value, embedded[i], embedded[i+1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Builds a set with the builder when a global is the Python set or
+frozenset type. Returns false for any other global.
+*/
+func (pm *PickleMachine) reduceSetWithBuilder(global PythonGlobal, args interface{}) (interface{}, bool, error) {
+	if global.Module != "__builtin__" && global.Module != "builtins" {
+		return nil, false, nil
+	}
+	if global.Name != "set" && global.Name != "frozenset" {
+		return nil, false, nil
+	}
+
+	argsPC, ok := args.(*pendingContainer)
+	if !ok || argsPC.kind != pendingTuple || len(argsPC.items) > 1 {
+		return nil, false, nil
+	}
+
+	var items []interface{}
+	if len(argsPC.items) == 1 {
+		pc, ok := argsPC.items[0].(*pendingContainer)
+		if !ok || pc.kind == pendingDict {
+			return nil, false, nil
+		}
+		if pc.kind == pendingTuple {
+			var err error
+			items, err = pm.embedAll(pc.items)
+			if err != nil {
+				return nil, true, err
+			}
+		} else {
+			items = pc.items
+		}
+	}
+
+	result, err := pm.builder.NewSet(items, global.Name == "frozenset")
+	return result, true, err
+}
@@ -0,0 +1,151 @@
+package stalecucumber
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type orderedDict struct {
+	Keys   []interface{}
+	Values map[interface{}]interface{}
+}
+
+type testTuple []interface{}
+
+type orderedBuilder struct {
+	DefaultContainerBuilder
+	sets int
+}
+
+func (ob *orderedBuilder) NewList() (interface{}, error) {
+	return &[]interface{}{}, nil
+}
+
+func (ob *orderedBuilder) Append(list interface{}, items []interface{}) (interface{}, error) {
+	l := list.(*[]interface{})
+	*l = append(*l, items...)
+	return l, nil
+}
+
+func (ob *orderedBuilder) NewDict() (interface{}, error) {
+	return &orderedDict{Values: make(map[interface{}]interface{})}, nil
+}
+
+func (ob *orderedBuilder) SetItem(dict interface{}, key interface{}, value interface{}) (interface{}, error) {
+	d := dict.(*orderedDict)
+	if _, ok := d.Values[key]; !ok {
+		d.Keys = append(d.Keys, key)
+	}
+	d.Values[key] = value
+	return d, nil
+}
+
+func (ob *orderedBuilder) NewTuple(items []interface{}) (interface{}, error) {
+	return testTuple(items), nil
+}
+
+func (ob *orderedBuilder) NewSet(items []interface{}, frozen bool) (interface{}, error) {
+	ob.sets++
+	return ob.DefaultContainerBuilder.NewSet(items, frozen)
+}
+
+func (ob *orderedBuilder) Finish(result interface{}) (interface{}, error) {
+	return []interface{}{"finished", result}, nil
+}
+
+func TestContainerBuilderDefault(t *testing.T) {
+	const input = "\x80\x02}q\x00(X\x01\x00\x00\x00bq\x01K\x01K\x02\x86q\x02X\x01\x00\x00\x00aq\x03]q\x04(]q\x05K\x01ah\x05c__builtin__\nset\nq\x06]q\x07(K\x03K\x04e\x85q\x08Rq\teX\x01\x00\x00\x00cq\nc__builtin__\nfrozenset\nq\x0b]q\x0c\x85q\rRq\x0eu."
+
+	u := NewUnpickler(strings.NewReader(input))
+	u.Builder = DefaultContainerBuilder{}
+	v, err := u.Unpickle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[interface{}]interface{}{
+		"b": []interface{}{int64(1), int64(2)},
+		"a": []interface{}{
+			[]interface{}{int64(1)},
+			[]interface{}{int64(1)},
+			PickleSet{int64(3): true, int64(4): true},
+		},
+		"c": PickleFrozenSet{},
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Fatalf("Got %v expected %v", v, expect)
+	}
+}
+
+func TestContainerBuilderOrdered(t *testing.T) {
+	inputs := []string{
+		"(dp0\nVz\np1\nI1\nsVy\np2\nI2\nsVx\np3\nI3\ns.",
+		"\x80\x02}q\x00(X\x01\x00\x00\x00zq\x01K\x01X\x01\x00\x00\x00yq\x02K\x02X\x01\x00\x00\x00xq\x03K\x03u.",
+	}
+	for _, input := range inputs {
+		u := NewUnpickler(strings.NewReader(input))
+		u.Builder = &orderedBuilder{}
+		v, err := u.Unpickle()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := v.([]interface{})
+		d := result[1].(*orderedDict)
+		if result[0] != "finished" || !reflect.DeepEqual(d.Keys, []interface{}{"z", "y", "x"}) {
+			t.Fatalf("Got wrong result %v", result)
+		}
+	}
+}
+
+func TestContainerBuilderSharedReferences(t *testing.T) {
+	const input = "\x80\x02}q\x00(X\x01\x00\x00\x00bq\x01K\x01K\x02\x86q\x02X\x01\x00\x00\x00aq\x03]q\x04(]q\x05K\x01ah\x05c__builtin__\nset\nq\x06]q\x07(K\x03K\x04e\x85q\x08Rq\teX\x01\x00\x00\x00cq\nc__builtin__\nfrozenset\nq\x0b]q\x0c\x85q\rRq\x0eu."
+
+	builder := &orderedBuilder{}
+	u := NewUnpickler(strings.NewReader(input))
+	u.Builder = builder
+	v, err := u.Unpickle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := v.([]interface{})[1].(*orderedDict)
+	if !reflect.DeepEqual(d.Keys, []interface{}{"b", "a", "c"}) {
+		t.Fatalf("Got wrong keys %v", d.Keys)
+	}
+	if !reflect.DeepEqual(d.Values["b"], testTuple{int64(1), int64(2)}) {
+		t.Fatalf("Got wrong tuple %v", d.Values["b"])
+	}
+
+	a := *d.Values["a"].(*[]interface{})
+	if len(a) != 3 || a[0] != a[1] {
+		t.Fatalf("Expected a shared list but got %v", a)
+	}
+	if !reflect.DeepEqual(a[2], PickleSet{int64(3): true, int64(4): true}) {
+		t.Fatalf("Got wrong set %v", a[2])
+	}
+	if !reflect.DeepEqual(d.Values["c"], PickleFrozenSet{}) || builder.sets != 2 {
+		t.Fatalf("Got wrong frozenset %v from %d sets", d.Values["c"], builder.sets)
+	}
+}
+
+type failingBuilder struct {
+	DefaultContainerBuilder
+}
+
+var errBuilderFailed = errors.New("builder failed")
+
+func (failingBuilder) NewDict() (interface{}, error) {
+	return nil, errBuilderFailed
+}
+
+func TestContainerBuilderError(t *testing.T) {
+	u := NewUnpickler(strings.NewReader("(lp0\n(dp1\na."))
+	u.Builder = failingBuilder{}
+	_, err := u.Unpickle()
+	if !errors.Is(err, errBuilderFailed) {
+		t.Fatalf("Expected builder error but got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package stalecucumber
+
+import "reflect"
+
+/*
+Converts a value from Unpickle before it is unpacked. See DecodeHooks.
+*/
+type DecodeHookFunc func(src interface{}) (interface{}, error)
+
+/*
+This type is a registry of conversions used by UnpackInto. Set it as
+"DecodeHooks" on the return value of UnpackInto. Each hook is registered
+for the reflect.Kind of a value from Unpickle and the type of a
+destination, such as reflect.Float64 and time.Time. The kind
+reflect.Invalid matches values of any kind.
+
+Hooks run before any of the usual rules of UnpackInto. If the value
+returned by a hook can be assigned to the destination it is, otherwise
+it is unpacked by the usual rules. This allows a hook to turn a Python
+string into an int for an enum, for example. A hook that can not convert
+a value may return it unchanged. If a hook returns ErrTargetTypeMismatch
+or ErrTargetTypeOverflow, "AllowMismatchedFields" zeroes the field the
+same as it does for any other mismatch.
+
+	hooks := stalecucumber.NewDecodeHooks()
+	hooks.Add(reflect.Float64, reflect.TypeOf(time.Time{}), func(src interface{}) (interface{}, error) {
+		sec, frac := math.Modf(src.(float64))
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	})
+
+	u := stalecucumber.UnpackInto(&dst)
+	u.DecodeHooks = hooks
+	err := u.From(stalecucumber.Unpickle(reader))
+*/
+type DecodeHooks struct {
+	hooks map[decodeHookKey]DecodeHookFunc
+}
+
+type decodeHookKey struct {
+	src reflect.Kind
+	dst reflect.Type
+}
+
+func NewDecodeHooks() *DecodeHooks {
+	return &DecodeHooks{hooks: make(map[decodeHookKey]DecodeHookFunc)}
+}
+
+/*
+Registers a hook for values of the kind src unpacked into destinations
+of the type dst. It replaces any hook already registered for the same
+kind and type.
+*/
+func (dh *DecodeHooks) Add(src reflect.Kind, dst reflect.Type, hook DecodeHookFunc) {
+	dh.hooks[decodeHookKey{src: src, dst: dst}] = hook
+}
+
+/*
+Runs the hook for a value and a destination type. Returns false if no
+hook is registered for them.
+*/
+func (dh *DecodeHooks) convert(src interface{}, dst reflect.Type) (interface{}, bool, error) {
+	if dh == nil || len(dh.hooks) == 0 {
+		return nil, false, nil
+	}
+
+	hook, ok := dh.hooks[decodeHookKey{src: reflect.ValueOf(src).Kind(), dst: dst}]
+	if !ok {
+		hook, ok = dh.hooks[decodeHookKey{src: reflect.Invalid, dst: dst}]
+		if !ok {
+			return nil, false, nil
+		}
+	}
+
+	v, err := hook(src)
+	return v, true, err
+}
@@ -0,0 +1,118 @@
+package stalecucumber
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type hookColor int
+
+const (
+	hookRed hookColor = iota
+	hookGreen
+)
+
+func testDecodeHooks() *DecodeHooks {
+	hooks := NewDecodeHooks()
+	hooks.Add(reflect.Float64, reflect.TypeOf(time.Time{}), func(src interface{}) (interface{}, error) {
+		sec, frac := math.Modf(src.(float64))
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	})
+	hooks.Add(reflect.String, reflect.TypeOf(hookRed), func(src interface{}) (interface{}, error) {
+		switch src.(string) {
+		case "red":
+			return int64(hookRed), nil
+		case "green":
+			return int64(hookGreen), nil
+		}
+		return nil, fmt.Errorf("Unknown color %q: %w", src, ErrTargetTypeMismatch)
+	})
+	hooks.Add(reflect.Slice, reflect.TypeOf(net.IP{}), func(src interface{}) (interface{}, error) {
+		var ip [4]byte
+		err := UnpackInto(&ip).From(src, nil)
+		if err != nil {
+			return nil, err
+		}
+		return net.IPv4(ip[0], ip[1], ip[2], ip[3]), nil
+	})
+	hooks.Add(reflect.Invalid, reflect.TypeOf(time.Duration(0)), func(src interface{}) (interface{}, error) {
+		seconds, err := Float(src, nil)
+		if err != nil {
+			return src, nil
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	})
+	return hooks
+}
+
+type hooked struct {
+	Created time.Time
+	Updated *time.Time
+	Color   hookColor
+	Address net.IP
+	Timeout time.Duration
+	Retries int
+}
+
+func TestDecodeHooks(t *testing.T) {
+	src := map[interface{}]interface{}{
+		"Created": 1500000000.5,
+		"Updated": float64(1600000000),
+		"Color":   "green",
+		"Address": []interface{}{int64(10), int64(0), int64(0), int64(1)},
+		"Timeout": 1.5,
+		"Retries": int64(3),
+	}
+
+	var dst hooked
+	u := UnpackInto(&dst)
+	u.DecodeHooks = testDecodeHooks()
+	err := u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !dst.Created.Equal(time.Unix(1500000000, 5e8)) || dst.Updated == nil ||
+		!dst.Updated.Equal(time.Unix(1600000000, 0)) || dst.Color != hookGreen ||
+		!dst.Address.Equal(net.IPv4(10, 0, 0, 1)) || dst.Timeout != 1500*time.Millisecond ||
+		dst.Retries != 3 {
+		t.Fatalf("Got %+v", dst)
+	}
+
+	//A hook can return a value unchanged for the usual rules
+	src["Timeout"] = "soon"
+	err = u.From(src, nil)
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+}
+
+func TestDecodeHooksMismatch(t *testing.T) {
+	src := map[interface{}]interface{}{
+		"Color":   "purple",
+		"Retries": int64(3),
+	}
+
+	var dst hooked
+	u := UnpackInto(&dst)
+	u.DecodeHooks = testDecodeHooks()
+	err := u.From(src, nil)
+	ue, ok := err.(UnpackingError)
+	if !ok || ue.Path != "Color" {
+		t.Fatalf("Expected an UnpackingError for Color but got %v", err)
+	}
+
+	dst.Color = hookGreen
+	u.AllowMismatchedFields = true
+	err = u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Color != hookRed || dst.Retries != 3 {
+		t.Fatalf("Got %+v", dst)
+	}
+}
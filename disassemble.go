@@ -0,0 +1,420 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+A single decoded opcode from a pickle stream, as produced by
+Disassembler.
+
+Offset is the byte offset of the opcode within the stream. Arg is the
+decoded argument of the opcode or nil if the opcode takes no argument.
+The types of Arg follow the same conversions as Unpickle, with the
+exception of GLOBAL and INST whose argument is the module and name
+separated by a single space.
+
+StackDepth and MarkDepth describe the state of the stack before the
+opcode executes. StackDepth counts marks as stack items, the same as
+the stack of the pickle machine does. For opcodes that consume a mark,
+including a POP of a mark, MarkOffset is the offset of the MARK opcode
+consumed. Otherwise it is -1.
+*/
+type Instruction struct {
+	Offset     int64
+	Opcode     uint8
+	Name       string
+	Arg        interface{}
+	StackDepth int
+	MarkDepth  int
+	MarkOffset int64
+
+	raw []byte
+}
+
+/*
+This type is returned whenever a Disassembler cannot decode the stream.
+Offset is the byte offset of the opcode that could not be decoded.
+*/
+type DisassemblyError struct {
+	Offset int64
+	Opcode uint8
+	Name   string
+	Err    error
+}
+
+func (de DisassemblyError) Error() string {
+	if len(de.Name) == 0 {
+		return fmt.Sprintf("Disassembly failed at offset %d. Cause:%v", de.Offset, de.Err)
+	}
+	return fmt.Sprintf("Disassembly failed at offset %d on opcode %s(0x%x). Cause:%v", de.Offset, de.Name, de.Opcode, de.Err)
+}
+
+func (de DisassemblyError) Unwrap() error {
+	return de.Err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+type disassemblerMark struct {
+	depth  int
+	offset int64
+}
+
+/*
+Decodes a pickle stream one opcode at a time without executing it. This
+is the equivalent of the "genops" function from Python's "pickletools"
+module, with the stack checking done by "pickletools.dis".
+
+A Disassembler never reads past the STOP opcode, so it can be used on
+a reader holding several pickles back to back.
+*/
+type Disassembler struct {
+	input   *countingReader
+	pm      PickleMachine
+	raw     *bytes.Buffer
+	depth   int
+	marks   []disassemblerMark
+	proto   int
+	stopped bool
+	//Stack errors are left for the pickle machine to report
+	lenient bool
+}
+
+func NewDisassembler(reader io.Reader) *Disassembler {
+	d := &Disassembler{}
+	d.input = &countingReader{r: reader}
+	d.pm.buf = &bytes.Buffer{}
+	d.pm.Reader = d.input
+	return d
+}
+
+func newCapturingDisassembler(reader io.Reader) *Disassembler {
+	d := NewDisassembler(reader)
+	d.raw = &bytes.Buffer{}
+	d.pm.Reader = io.TeeReader(d.input, d.raw)
+	return d
+}
+
+/*
+Returns the highest protocol version of any opcode decoded so far.
+*/
+func (d *Disassembler) Protocol() int {
+	return d.proto
+}
+
+/*
+Decodes the next opcode. After the STOP opcode has been returned all
+further calls return io.EOF. Any failure is returned as an instance of
+DisassemblyError.
+*/
+func (d *Disassembler) Next() (Instruction, error) {
+	var ins Instruction
+	if d.stopped {
+		return ins, io.EOF
+	}
+
+	ins.Offset = d.input.n
+	ins.MarkOffset = -1
+	ins.StackDepth = d.depth
+	ins.MarkDepth = len(d.marks)
+	if d.raw != nil {
+		d.raw.Reset()
+	}
+
+	err := d.pm.readBinaryInto(&ins.Opcode, false)
+	if err != nil {
+		return ins, d.error(ins, err)
+	}
+
+	info := opcodeInfos[ins.Opcode]
+	if info == nil {
+		return ins, d.error(ins, ErrOpcodeInvalid)
+	}
+	ins.Name = info.Name
+
+	ins.Arg, err = d.readArgument(info.Arg)
+	if err != nil {
+		return ins, d.error(ins, err)
+	}
+
+	err = d.simulate(&ins, info)
+	if err != nil && !d.lenient {
+		return ins, d.error(ins, err)
+	}
+
+	if info.Proto > d.proto {
+		d.proto = info.Proto
+	}
+	if ins.Opcode == OPCODE_PROTO {
+		d.proto = int(ins.Arg.(int64))
+	}
+	if ins.Opcode == OPCODE_STOP {
+		d.stopped = true
+	}
+
+	if d.raw != nil {
+		ins.raw = append([]byte(nil), d.raw.Bytes()...)
+	}
+
+	return ins, nil
+}
+
+func (d *Disassembler) error(ins Instruction, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrInputTruncated
+	}
+	return DisassemblyError{
+		Offset: ins.Offset,
+		Opcode: ins.Opcode,
+		Name:   ins.Name,
+		Err:    err,
+	}
+}
+
+func (d *Disassembler) simulate(ins *Instruction, info *opcodeInfo) error {
+	if info.PushMark {
+		d.marks = append(d.marks, disassemblerMark{depth: d.depth, offset: ins.Offset})
+		d.depth++
+		return nil
+	}
+
+	//POP removes a mark when one is on top of the stack. Python 2
+	//writes this for tuples that contain themselves.
+	if ins.Opcode == OPCODE_POP && len(d.marks) != 0 &&
+		d.marks[len(d.marks)-1].depth == d.depth-1 {
+		mark := d.marks[len(d.marks)-1]
+		d.marks = d.marks[:len(d.marks)-1]
+		ins.MarkOffset = mark.offset
+		d.depth = mark.depth
+		return nil
+	}
+
+	if info.Mark {
+		if len(d.marks) == 0 {
+			return ErrMarkNotFound
+		}
+		mark := d.marks[len(d.marks)-1]
+		d.marks = d.marks[:len(d.marks)-1]
+		ins.MarkOffset = mark.offset
+		d.depth = mark.depth
+	}
+
+	//Items can never be popped from below the topmost mark
+	floor := 0
+	if len(d.marks) != 0 {
+		floor = d.marks[len(d.marks)-1].depth + 1
+	}
+	if d.depth-info.Before < floor {
+		return ErrStackTooSmall
+	}
+
+	d.depth += info.After - info.Before
+	return nil
+}
+
+func (d *Disassembler) readArgument(kind opcodeArgument) (interface{}, error) {
+	pm := &d.pm
+	switch kind {
+	case argNone:
+		return nil, nil
+	case argUint1:
+		var v uint8
+		err := pm.readBinaryInto(&v, false)
+		return int64(v), err
+	case argUint2:
+		var v uint16
+		err := pm.readBinaryInto(&v, false)
+		return int64(v), err
+	case argInt4:
+		var v int32
+		err := pm.readBinaryInto(&v, false)
+		return int64(v), err
+	case argFloat8:
+		var v float64
+		err := pm.readBinaryInto(&v, true)
+		return v, err
+	case argDecimalNLShort:
+		str, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseIntLiteral(str)
+	case argDecimalNLLong:
+		str, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseLongLiteral(str)
+	case argFloatNL:
+		str, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseFloatLiteral(str)
+	case argStringNL:
+		str, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		return unquoteStringBytes(str)
+	case argStringNLNoEscape:
+		return pm.readString()
+	case argStringNLNoEscapePair:
+		module, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		name, err := pm.readString()
+		if err != nil {
+			return nil, err
+		}
+		return module + " " + name, nil
+	case argUnicodeNL:
+		str, err := pm.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		return decodeRawUnicodeEscape(str)
+	case argString1:
+		var l uint8
+		err := pm.readBinaryInto(&l, false)
+		if err != nil {
+			return nil, err
+		}
+		return pm.readFixedLengthString(int64(l))
+	case argString4, argUnicode4:
+		var l int32
+		err := pm.readBinaryInto(&l, false)
+		if err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return nil, fmt.Errorf("Negative string length of %d", l)
+		}
+		return pm.readFixedLengthString(int64(l))
+	case argLong1:
+		var l uint8
+		err := pm.readBinaryInto(&l, false)
+		if err != nil {
+			return nil, err
+		}
+		data, err := pm.readFixedLengthRaw(int64(l))
+		if err != nil {
+			return nil, err
+		}
+		return decodeLong(data), nil
+	case argLong4:
+		var l int32
+		err := pm.readBinaryInto(&l, false)
+		if err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return nil, fmt.Errorf("Negative long length of %d", l)
+		}
+		data, err := pm.readFixedLengthRaw(int64(l))
+		if err != nil {
+			return nil, err
+		}
+		return decodeLong(data), nil
+	}
+
+	return nil, fmt.Errorf("Unknown argument kind %d", kind)
+}
+
+/*
+Writes a human readable listing of a pickle to w, one opcode per line.
+The output follows "pickletools.dis" from Python. Each line holds the
+byte offset, the opcode, the opcode name indented by the mark nesting,
+and the decoded argument.
+
+If the pickle is malformed or truncated, the listing up to that point is
+written and a DisassemblyError giving the offset of the failure is
+returned.
+*/
+func Disassemble(w io.Writer, r io.Reader) error {
+	d := NewDisassembler(r)
+	for {
+		ins, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, ins.String()+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.depth != 0 {
+		return DisassemblyError{Offset: d.input.n, Err: fmt.Errorf("Stack not empty after STOP: %d items remain", d.depth)}
+	}
+
+	_, err := fmt.Fprintf(w, "highest protocol among opcodes = %d\n", d.proto)
+	return err
+}
+
+/*
+Formats the instruction as a single line of "pickletools.dis" output.
+*/
+func (ins Instruction) String() string {
+	var code string
+	if ins.Opcode >= 0x20 && ins.Opcode < 0x7f {
+		code = string(rune(ins.Opcode))
+	} else {
+		code = fmt.Sprintf("\\x%02x", ins.Opcode)
+	}
+
+	line := fmt.Sprintf("%5d: %-4s %s%s", ins.Offset, code, strings.Repeat("    ", ins.MarkDepth), ins.Name)
+	if ins.Arg != nil || ins.MarkOffset != -1 {
+		if pad := 10 - len(ins.Name); pad > 0 {
+			line += strings.Repeat(" ", pad)
+		}
+		if ins.Arg != nil {
+			line += " " + formatArgument(ins.Arg)
+		}
+		if ins.MarkOffset != -1 {
+			line += fmt.Sprintf(" (MARK at %d)", ins.MarkOffset)
+		}
+	}
+	return line
+}
+
+func formatArgument(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		q := strconv.Quote(v)
+		if !strings.ContainsRune(v, '\'') {
+			q = "'" + strings.Replace(q[1:len(q)-1], `\"`, `"`, -1) + "'"
+		}
+		return q
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return formatFloatLiteral(v)
+	case *big.Int:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", arg)
+}
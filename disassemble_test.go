@@ -0,0 +1,153 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleProtocol1(t *testing.T) {
+	const input = "}q\x00X\x01\x00\x00\x00aq\x01(K\x01K\x02tq\x02s."
+	const expect = `    0: }    EMPTY_DICT
+    1: q    BINPUT     0
+    3: X    BINUNICODE 'a'
+    9: q    BINPUT     1
+   11: (    MARK
+   12: K        BININT1    1
+   14: K        BININT1    2
+   16: t        TUPLE      (MARK at 11)
+   17: q    BINPUT     2
+   19: s    SETITEM
+   20: .    STOP
+highest protocol among opcodes = 1
+`
+	buf := &bytes.Buffer{}
+	err := Disassemble(buf, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != expect {
+		t.Fatalf("Got\n%s\nexpected\n%s", buf.String(), expect)
+	}
+}
+
+func TestDisassemblerStackDepth(t *testing.T) {
+	d := NewDisassembler(strings.NewReader("(lp0\nI1\naI01\na."))
+	var depths []int
+	var marks []int
+	var args []interface{}
+	for {
+		ins, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		depths = append(depths, ins.StackDepth)
+		marks = append(marks, ins.MarkDepth)
+		args = append(args, ins.Arg)
+	}
+
+	expectDepths := []int{0, 1, 1, 1, 2, 1, 2, 1}
+	expectMarks := []int{0, 1, 0, 0, 0, 0, 0, 0}
+	if len(depths) != len(expectDepths) {
+		t.Fatalf("Got %d instructions, expected %d", len(depths), len(expectDepths))
+	}
+	for i := range depths {
+		if depths[i] != expectDepths[i] || marks[i] != expectMarks[i] {
+			t.Fatalf("Instruction %d: got depth %d marks %d, expected %d %d", i, depths[i], marks[i], expectDepths[i], expectMarks[i])
+		}
+	}
+
+	if args[3] != int64(1) || args[5] != true {
+		t.Fatalf("Got wrong arguments %v", args)
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	cases := []struct {
+		input  string
+		offset int64
+		err    error
+	}{
+		{"\x80\x02K", 2, ErrInputTruncated},
+		{"\x80\x02K\x01", 4, ErrInputTruncated},
+		{"I1\n\xff.", 3, ErrOpcodeInvalid},
+		{"I1\nI2\nl.", 6, ErrMarkNotFound},
+		{"(a.", 1, ErrStackTooSmall},
+	}
+
+	for _, c := range cases {
+		err := Disassemble(&bytes.Buffer{}, strings.NewReader(c.input))
+		de, ok := err.(DisassemblyError)
+		if !ok {
+			t.Fatalf("%q: expected %T but got (%T)%v", c.input, de, err, err)
+		}
+		if de.Offset != c.offset {
+			t.Errorf("%q: expected failure at offset %d but got %d", c.input, c.offset, de.Offset)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%q: expected cause %v but got %v", c.input, c.err, de.Err)
+		}
+	}
+}
+
+func TestDisassemblePopMark(t *testing.T) {
+	//Python 2 writes this for t = ([],); t[0].append(t)
+	const input = "((lp0\n(g0\ntp1\na00g1\n."
+	const expect = `    0: (    MARK
+    1: (        MARK
+    2: l            LIST       (MARK at 1)
+    3: p        PUT        0
+    6: (        MARK
+    7: g            GET        0
+   10: t            TUPLE      (MARK at 6)
+   11: p        PUT        1
+   14: a        APPEND
+   15: 0        POP
+   16: 0        POP        (MARK at 0)
+   17: g    GET        1
+   20: .    STOP
+highest protocol among opcodes = 0
+`
+	buf := &bytes.Buffer{}
+	err := Disassemble(buf, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != expect {
+		t.Fatalf("Got\n%s\nexpected\n%s", buf.String(), expect)
+	}
+}
+
+func TestDisassembleSpecialFloats(t *testing.T) {
+	//pickletools prints these as Python's repr does
+	const input = "\x80\x02]q\x00(G\x7f\xf0\x00\x00\x00\x00\x00\x00G\xff\xf0\x00\x00\x00\x00\x00\x00G\x7f\xf8\x00\x00\x00\x00\x00\x00G?\xf8\x00\x00\x00\x00\x00\x00GCA\xc3y7\xe0\x80\x00e."
+	const expect = `    0: \x80 PROTO      2
+    2: ]    EMPTY_LIST
+    3: q    BINPUT     0
+    5: (    MARK
+    6: G        BINFLOAT   inf
+   15: G        BINFLOAT   -inf
+   24: G        BINFLOAT   nan
+   33: G        BINFLOAT   1.5
+   42: G        BINFLOAT   1e+16
+   51: e        APPENDS    (MARK at 5)
+   52: .    STOP
+highest protocol among opcodes = 2
+`
+	buf := &bytes.Buffer{}
+	err := Disassemble(buf, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != expect {
+		t.Fatalf("Got\n%s\nexpected\n%s", buf.String(), expect)
+	}
+}
@@ -0,0 +1,95 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordingResolver struct {
+	names []PythonGlobal
+}
+
+func (rr *recordingResolver) Resolve(module string, name string, args []interface{}) (interface{}, error) {
+	rr.names = append(rr.names, PythonGlobal{module, name})
+	return PickleNone{}, nil
+}
+
+func TestUnpicklerFixImports(t *testing.T) {
+	const input = "\x80\x02(cexceptions\nValueError\n)Rccopy_reg\n_reconstructor\n)Rc__builtin__\nxrange\n)Rcmymodule\nThing\n)Rt."
+
+	cases := []struct {
+		fix    bool
+		expect []PythonGlobal
+	}{
+		{false, []PythonGlobal{
+			{"exceptions", "ValueError"},
+			{"copy_reg", "_reconstructor"},
+			{"__builtin__", "xrange"},
+			{"mymodule", "Thing"}}},
+		{true, []PythonGlobal{
+			{"builtins", "ValueError"},
+			{"copyreg", "_reconstructor"},
+			{"builtins", "range"},
+			{"mymodule", "Thing"}}},
+	}
+
+	for _, c := range cases {
+		resolver := &recordingResolver{}
+		u := NewUnpickler(strings.NewReader(input))
+		u.Resolver = resolver
+		u.FixImports = c.fix
+		_, err := u.Unpickle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resolver.names, c.expect) {
+			t.Errorf("Got %v expected %v", resolver.names, c.expect)
+		}
+	}
+}
+
+func TestUnpickleBuiltinsSet(t *testing.T) {
+	//Python 3 writes sets with "builtins" when "fix_imports" is off
+	const input = "\x80\x02cbuiltins\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03."
+
+	v, err := Set(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, map[interface{}]bool{int64(1): true, int64(2): true}) {
+		t.Fatalf("Got %v", v)
+	}
+}
+
+func TestPicklerGlobalNames(t *testing.T) {
+	cases := []struct {
+		version int
+		module  string
+		name    string
+		expect  string
+	}{
+		{0, "builtins", "set", "c__builtin__\nset\n"},
+		{2, "copyreg", "_reconstructor", "ccopy_reg\n_reconstructor\n"},
+		{2, "builtins", "ConnectionError", "cexceptions\nOSError\n"},
+		{3, "builtins", "set", "cbuiltins\nset\n"},
+		{2, "mymodule", "Thing", "cmymodule\nThing\n"},
+	}
+
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		p := NewPickler(buf)
+		p.PythonVersion = c.version
+		p.dumpGlobal(c.module, c.name)
+		_, err := p.writeProgram()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := "\x80\x02" + c.expect + "."
+		if buf.String() != expect {
+			t.Errorf("Got %q expected %q", buf.String(), expect)
+		}
+	}
+}
@@ -39,6 +39,30 @@ func String(v interface{}, err error) (string, error) {
 	return "", newWrongTypeError(v, vs)
 }
 
+/*
+This helper attempts to convert the return value of Unpickle into a []byte.
+PickleBytes, PickleByteArray and string are accepted.
+
+If Unpickle returns an error that error is returned immediately.
+
+If the value cannot be converted an error is returned.
+*/
+func Bytes(v interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch vb := v.(type) {
+	case PickleBytes:
+		return []byte(vb), nil
+	case PickleByteArray:
+		return vb, nil
+	case string:
+		return []byte(vb), nil
+	}
+
+	return nil, newWrongTypeError(v, []byte(nil))
+}
+
 /*
 This helper attempts to convert the return value of Unpickle into a int64.
 
@@ -62,6 +86,12 @@ func Int(v interface{}, err error) (int64, error) {
 		}
 	}
 
+	if vn, ok := v.(PickleNumber); ok {
+		if vi, err := vn.Int64(); err == nil {
+			return vi, nil
+		}
+	}
+
 	return 0, newWrongTypeError(v, vi)
 
 }
@@ -103,6 +133,15 @@ func Big(v interface{}, err error) (*big.Int, error) {
 		return vb, nil
 	}
 
+	switch vn := v.(type) {
+	case int64:
+		return big.NewInt(vn), nil
+	case PickleNumber:
+		if vb, err := vn.BigInt(); err == nil {
+			return vb, nil
+		}
+	}
+
 	return nil, newWrongTypeError(v, vb)
 
 }
@@ -124,6 +163,18 @@ func Float(v interface{}, err error) (float64, error) {
 		return vf, nil
 	}
 
+	switch vn := v.(type) {
+	case float32:
+		return float64(vn), nil
+	case *big.Float:
+		vf, _ = vn.Float64()
+		return vf, nil
+	case PickleNumber:
+		if vf, err := vn.Float64(); err == nil {
+			return vf, nil
+		}
+	}
+
 	return 0.0, newWrongTypeError(v, vf)
 }
 
@@ -170,6 +221,7 @@ func Dict(v interface{}, err error) (map[interface{}]interface{}, error) {
 
 /*
 This helper attempts to convert the return value of Unpickle into a map[interface{}]bool.
+Both PickleSet and PickleFrozenSet are accepted.
 
 If Unpickle returns an error that error is returned immediately.
 
@@ -185,6 +237,13 @@ func Set(v interface{}, err error) (map[interface{}]bool, error) {
 		return vd, nil
 	}
 
+	switch vs := v.(type) {
+	case PickleSet:
+		return vs, nil
+	case PickleFrozenSet:
+		return vs, nil
+	}
+
 	return nil, newWrongTypeError(v, vd)
 }
 
@@ -209,8 +268,13 @@ func tryDictToDictString(src map[interface{}]interface{}) (map[string]interface{
 	dst := make(map[string]interface{}, len(src))
 
 	for k, v := range src {
-		kstr, ok := k.(string)
-		if !ok {
+		var kstr string
+		switch k := k.(type) {
+		case string:
+			kstr = k
+		case PickleBytes:
+			kstr = string(k)
+		default:
 			return nil, newWrongTypeError(src, dst)
 		}
 		dst[kstr] = v
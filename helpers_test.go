@@ -3,6 +3,7 @@ package stalecucumber
 import "reflect"
 import "testing"
 import "strings"
+import "math"
 import "math/big"
 
 func TestHelperDictString(t *testing.T) {
@@ -60,3 +61,54 @@ func TestIntHelper(t *testing.T) {
 		t.Fatalf("should not have unpickled:%d", result)
 	}
 }
+
+func TestNormalizeHelper(t *testing.T) {
+	const input = "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01}q\x02X\x01\x00\x00\x00bq\x03]q\x04(]q\x05K\x01a]q\x06K\x02aesX\x01\x00\x00\x00nq\x07\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@X\x01\x00\x00\x00mq\x08\x8a\x08\x00\x00\x00\x00\x00\x00\x00@X\x01\x00\x00\x00tq\t}q\nK\x01K\x02\x86q\x0bX\x01\x00\x00\x00pq\x0csu."
+	result, err := Normalize(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := new(big.Int).Lsh(big.NewInt(1), 70)
+	expect := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{[]interface{}{int64(1)}, []interface{}{int64(2)}},
+		},
+		"n": n,
+		"m": int64(1 << 62),
+		"t": map[interface{}]interface{}{
+			[2]interface{}{int64(1), int64(2)}: "p",
+		},
+	}
+	if !reflect.DeepEqual(expect, result) {
+		t.Fatalf("Got %v expected %v", result, expect)
+	}
+
+	result, err = Normalize([]interface{}{PickleBytes("a"), PickleByteArray("b"), PickleNone{}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]interface{}{[]byte("a"), []byte("b"), PickleNone{}}, result) {
+		t.Fatalf("Got %v", result)
+	}
+
+	result, err = Normalize([]interface{}{big.NewInt(math.MinInt64)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]interface{}{int64(math.MinInt64)}, result) {
+		t.Fatalf("Got %v", result)
+	}
+
+	//A list that contains itself is converted once
+	cycle := make([]interface{}, 1)
+	cycle[0] = cycle
+	result, err = Normalize(cycle, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultList := result.([]interface{})
+	if reflect.ValueOf(resultList[0]).Pointer() != reflect.ValueOf(resultList).Pointer() {
+		t.Fatalf("Cycle was not preserved")
+	}
+}
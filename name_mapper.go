@@ -0,0 +1,108 @@
+package stalecucumber
+
+import (
+	"strings"
+	"unicode"
+)
+
+/*
+Maps the names of struct fields to the keys of a Python dict. It is used
+by the Pickler and by UnpackInto for every field that does not have a
+name in its "pickle" tag.
+
+FieldKey returns the key a field is pickled as. When unpacking, a key
+that is not the FieldKey of any field is passed to KeyMatches with the
+Go name of each field in turn, to find a field that is spelled
+differently.
+*/
+type NameMapper interface {
+	FieldKey(name string) string
+	KeyMatches(key string, name string) bool
+}
+
+/*
+The NameMapper used when none is set. Fields are pickled with their Go
+names. When unpacking a key also matches the Go name of a field with
+the first character of the key capitalized, since structs do not export
+fields with a lower case first character.
+*/
+type DefaultNameMapper struct{}
+
+func (DefaultNameMapper) FieldKey(name string) string {
+	return name
+}
+
+func (DefaultNameMapper) KeyMatches(key string, name string) bool {
+	if key == name {
+		return true
+	}
+	return len(key) != 0 && strings.ToUpper(key[0:1])+key[1:] == name
+}
+
+/*
+A NameMapper that pickles fields with the Python convention of
+snake_case names. Initialisms are kept together, so "UserID" becomes
+"user_id" and "HTTPServer" becomes "http_server". When unpacking a key
+matches a field if they are the same once underscores are removed and
+case is ignored.
+*/
+type SnakeCaseNameMapper struct{}
+
+func (SnakeCaseNameMapper) FieldKey(name string) string {
+	return snakeCase(name)
+}
+
+func (SnakeCaseNameMapper) KeyMatches(key string, name string) bool {
+	return strings.EqualFold(strings.Replace(key, "_", "", -1), name)
+}
+
+/*
+A NameMapper that pickles fields with their Go names and matches keys
+to fields ignoring case when unpacking.
+*/
+type CaseInsensitiveNameMapper struct{}
+
+func (CaseInsensitiveNameMapper) FieldKey(name string) string {
+	return name
+}
+
+func (CaseInsensitiveNameMapper) KeyMatches(key string, name string) bool {
+	return strings.EqualFold(key, name)
+}
+
+/*
+Converts a CamelCase name to snake_case. A word starts at each upper
+case letter that follows a lower case letter or a digit, and at the last
+upper case letter of a run that is followed by a lower case letter. A
+run followed by an "s" that ends the word, as in UserIDs or URLs, is a
+plural initialism and is kept together.
+*/
+func snakeCase(name string) string {
+	runes := []rune(name)
+	buf := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i != 0 {
+				prev := runes[i-1]
+				nextLower := i+1 != len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+					(unicode.IsUpper(prev) && nextLower && !isPluralEnd(runes, i+1)) {
+					buf = append(buf, '_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		buf = append(buf, r)
+	}
+	return string(buf)
+}
+
+/*
+Reports if the rune at i is an "s" that ends a word.
+*/
+func isPluralEnd(runes []rune, i int) bool {
+	if runes[i] != 's' {
+		return false
+	}
+	return i+1 == len(runes) || !unicode.IsLower(runes[i+1])
+}
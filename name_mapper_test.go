@@ -0,0 +1,100 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for name, expect := range map[string]string{
+		"UserID":     "user_id",
+		"ID":         "id",
+		"HTTPServer": "http_server",
+		"Name":       "name",
+		"LastLogin":  "last_login",
+		"Field2Name": "field2_name",
+		"already_ok": "already_ok",
+		"UserIDs":    "user_ids",
+		"URLs":       "urls",
+		"IPs":        "ips",
+		"IDsByName":  "ids_by_name",
+		"HTTPSocket": "http_socket",
+	} {
+		if got := snakeCase(name); got != expect {
+			t.Errorf("Got %q for %q expected %q", got, name, expect)
+		}
+	}
+}
+
+type mappedUser struct {
+	UserID     int64
+	FirstName  string
+	HTTPServer string
+	Nickname   string `pickle:"nick"`
+}
+
+func TestSnakeCaseNameMapper(t *testing.T) {
+	u := mappedUser{UserID: 7, FirstName: "Fizz", HTTPServer: "example.com", Nickname: "fb"}
+
+	buf := &bytes.Buffer{}
+	p := NewPickler(buf)
+	p.NameMapper = SnakeCaseNameMapper{}
+	_, err := p.Pickle(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[interface{}]interface{}{
+		"user_id":     int64(7),
+		"first_name":  "Fizz",
+		"http_server": "example.com",
+		"nick":        "fb",
+	}
+	sanityCheck(bytes.NewReader(buf.Bytes()), t, expect)
+
+	var dst mappedUser
+	unpacker := UnpackInto(&dst)
+	unpacker.NameMapper = SnakeCaseNameMapper{}
+	unpacker.AllowMissingFields = false
+	err = unpacker.From(Unpickle(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, u) {
+		t.Fatalf("Got %v expected %v", dst, u)
+	}
+
+	//Keys written another way still match
+	dst = mappedUser{}
+	unpacker = UnpackInto(&dst)
+	unpacker.NameMapper = SnakeCaseNameMapper{}
+	err = unpacker.From(map[interface{}]interface{}{"userid": int64(8), "First_Name": "Buzz"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.UserID != 8 || dst.FirstName != "Buzz" {
+		t.Fatalf("Got %v", dst)
+	}
+}
+
+func TestCaseInsensitiveNameMapper(t *testing.T) {
+	src := map[interface{}]interface{}{"USERID": int64(9), "firstname": "Fizz"}
+
+	var dst mappedUser
+	unpacker := UnpackInto(&dst)
+	unpacker.AllowMissingFields = false
+	err := unpacker.From(src, nil)
+	if err == nil {
+		t.Fatal("Should have failed without a NameMapper")
+	}
+
+	unpacker.NameMapper = CaseInsensitiveNameMapper{}
+	err = unpacker.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.UserID != 9 || dst.FirstName != "Fizz" {
+		t.Fatalf("Got %v", dst)
+	}
+}
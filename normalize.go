@@ -0,0 +1,111 @@
+package stalecucumber
+
+import (
+	"math/big"
+	"reflect"
+)
+
+/*
+This helper converts the return value of Unpickle into the types a Go
+program would use on its own, such as for "encoding/json" or templates.
+Unlike DictString it converts every level of the value.
+
+	dicts with only string keys -> map[string]interface{}
+	other dicts -> map[interface{}]interface{}
+	lists and tuples -> []interface{}
+	*big.Int -> int64, if it fits
+	PickleBytes, PickleByteArray -> []byte
+
+The values of dicts and the items of lists and tuples are converted the
+same way. Any other value is returned unchanged. A list or dict that
+appears more than once in the value, including one that contains itself,
+is converted once and shared the same as in the pickle.
+
+If Unpickle returns an error that error is returned immediately.
+*/
+func Normalize(v interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	n := normalizer{seen: make(map[normalizedKey]interface{})}
+	return n.normalize(v), nil
+}
+
+type normalizer struct {
+	//Converted lists and dicts by the address of the original
+	seen map[normalizedKey]interface{}
+}
+
+type normalizedKey struct {
+	p uintptr
+	n int
+}
+
+func (n normalizer) normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		return n.normalizeDict(v)
+	case []interface{}:
+		return n.normalizeList(v)
+	case *big.Int:
+		if v.IsInt64() {
+			return v.Int64()
+		}
+	case PickleBytes:
+		return []byte(v)
+	case PickleByteArray:
+		return []byte(v)
+	}
+	return v
+}
+
+func (n normalizer) normalizeDict(src map[interface{}]interface{}) interface{} {
+	id := normalizedKey{p: reflect.ValueOf(src).Pointer(), n: -1}
+	if dst, ok := n.seen[id]; ok {
+		return dst
+	}
+
+	stringKeys := true
+	for k := range src {
+		if _, ok := stringValue(k); !ok {
+			stringKeys = false
+			break
+		}
+	}
+
+	if !stringKeys {
+		dst := make(map[interface{}]interface{}, len(src))
+		n.seen[id] = dst
+		for k, kv := range src {
+			dst[k] = n.normalize(kv)
+		}
+		return dst
+	}
+
+	dst := make(map[string]interface{}, len(src))
+	n.seen[id] = dst
+	for k, kv := range src {
+		s, _ := stringValue(k)
+		dst[s] = n.normalize(kv)
+	}
+	return dst
+}
+
+func (n normalizer) normalizeList(src []interface{}) interface{} {
+	dst := make([]interface{}, len(src))
+	//An empty list has no address to share
+	if len(src) == 0 {
+		return dst
+	}
+
+	id := normalizedKey{p: reflect.ValueOf(src).Pointer(), n: len(src)}
+	if prev, ok := n.seen[id]; ok {
+		return prev
+	}
+	n.seen[id] = dst
+	for i, item := range src {
+		dst[i] = n.normalize(item)
+	}
+	return dst
+}
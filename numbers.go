@@ -0,0 +1,104 @@
+package stalecucumber
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+/*
+Controls the Go types that Python numbers are converted to by an
+Unpickler. The zero value converts numbers the same way as Unpickle. The
+flags may be combined.
+
+NumberLongAsInt64 converts Python longs that fit into an int64 to int64,
+so that only values that overflow an int64 are returned as *big.Int.
+
+NumberFloatAsFloat32 converts Python floats to float32.
+
+NumberAsBig converts every Python int and long to *big.Int and every
+Python float to *big.Float, except for NaN which stays a float64. It takes
+precedence over the flags above.
+
+NumberAsString converts every Python int, long and float to PickleNumber.
+It takes precedence over all other flags.
+
+Booleans are never affected.
+*/
+type NumberMode uint
+
+const (
+	NumberLongAsInt64 NumberMode = 1 << iota
+	NumberFloatAsFloat32
+	NumberAsBig
+	NumberAsString
+)
+
+/*
+This type holds a Python number as its literal text. It is used when an
+Unpickler has NumberAsString set and is similar to json.Number from
+"encoding/json". Integers are written in decimal and floats the same way
+Python "repr" writes them.
+*/
+type PickleNumber string
+
+func (pn PickleNumber) String() string {
+	return string(pn)
+}
+
+func (pn PickleNumber) Int64() (int64, error) {
+	return strconv.ParseInt(string(pn), 10, 64)
+}
+
+func (pn PickleNumber) Float64() (float64, error) {
+	return strconv.ParseFloat(string(pn), 64)
+}
+
+func (pn PickleNumber) BigInt() (*big.Int, error) {
+	v, ok := new(big.Int).SetString(string(pn), 10)
+	if !ok {
+		return nil, fmt.Errorf("PickleNumber %q is not an integer", string(pn))
+	}
+	return v, nil
+}
+
+func (pm *PickleMachine) pushInt(v int64) {
+	switch {
+	case pm.numbers&NumberAsString != 0:
+		pm.push(PickleNumber(strconv.FormatInt(v, 10)))
+	case pm.numbers&NumberAsBig != 0:
+		pm.push(big.NewInt(v))
+	default:
+		pm.push(v)
+	}
+}
+
+func (pm *PickleMachine) pushLong(v *big.Int) {
+	switch {
+	case pm.numbers&NumberAsString != 0:
+		pm.push(PickleNumber(v.String()))
+	case pm.numbers&NumberAsBig != 0:
+		pm.push(v)
+	case pm.numbers&NumberLongAsInt64 != 0 && v.IsInt64():
+		pm.push(v.Int64())
+	default:
+		pm.push(v)
+	}
+}
+
+func (pm *PickleMachine) pushFloat(v float64) {
+	switch {
+	case pm.numbers&NumberAsString != 0:
+		pm.push(PickleNumber(formatFloatLiteral(v)))
+	case pm.numbers&NumberAsBig != 0 && math.IsNaN(v):
+		//big.Float can not hold NaN
+		pm.push(v)
+	case pm.numbers&NumberAsBig != 0:
+		pm.push(big.NewFloat(v))
+	case pm.numbers&NumberFloatAsFloat32 != 0:
+		pm.push(float32(v))
+	default:
+		pm.push(v)
+	}
+}
@@ -0,0 +1,100 @@
+package stalecucumber
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+The tuple (5, 5000000000, 2**70, 1.5, True, -3) pickled with protocol 2.
+*/
+const numbersInput = "\x80\x02(K\x05\x8a\x05\x00\xf2\x05*\x01\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@G?\xf8\x00\x00\x00\x00\x00\x00\x88J\xfd\xff\xff\xfft."
+
+func unpickleNumbers(t *testing.T, input string, mode NumberMode) []interface{} {
+	u := NewUnpickler(strings.NewReader(input))
+	u.NumberMode = mode
+	v, err := ListOrTuple(u.Unpickle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestNumberModes(t *testing.T) {
+	huge, _ := new(big.Int).SetString("1180591620717411303424", 10)
+
+	cases := []struct {
+		mode   NumberMode
+		expect []interface{}
+	}{
+		{0, []interface{}{int64(5), big.NewInt(5000000000), huge, 1.5, true, int64(-3)}},
+		{NumberLongAsInt64, []interface{}{int64(5), int64(5000000000), huge, 1.5, true, int64(-3)}},
+		{NumberLongAsInt64 | NumberFloatAsFloat32, []interface{}{int64(5), int64(5000000000), huge, float32(1.5), true, int64(-3)}},
+		{NumberAsBig | NumberLongAsInt64, []interface{}{big.NewInt(5), big.NewInt(5000000000), huge, big.NewFloat(1.5), true, big.NewInt(-3)}},
+		{NumberAsString, []interface{}{PickleNumber("5"), PickleNumber("5000000000"), PickleNumber("1180591620717411303424"), PickleNumber("1.5"), true, PickleNumber("-3")}},
+	}
+
+	for _, c := range cases {
+		v := unpickleNumbers(t, numbersInput, c.mode)
+		if !reflect.DeepEqual(v, c.expect) {
+			t.Errorf("Mode %d: got %v expected %v", c.mode, v, c.expect)
+		}
+	}
+}
+
+func TestNumberModesProtocol0(t *testing.T) {
+	const input = "(lI5\naL5000000000L\naI1180591620717411303424\naF1.5\na."
+
+	v := unpickleNumbers(t, input, NumberLongAsInt64)
+	huge, _ := new(big.Int).SetString("1180591620717411303424", 10)
+	expect := []interface{}{int64(5), int64(5000000000), huge, 1.5}
+	if !reflect.DeepEqual(v, expect) {
+		t.Fatalf("Got %v expected %v", v, expect)
+	}
+}
+
+func TestNumberLongAsInt64Bounds(t *testing.T) {
+	const input = "(lL-9223372036854775808L\naL9223372036854775807L\naL9223372036854775808L\na."
+
+	v := unpickleNumbers(t, input, NumberLongAsInt64)
+	over, _ := new(big.Int).SetString("9223372036854775808", 10)
+	expect := []interface{}{int64(math.MinInt64), int64(math.MaxInt64), over}
+	if !reflect.DeepEqual(v, expect) {
+		t.Fatalf("Got %v expected %v", v, expect)
+	}
+}
+
+func TestUnpackPickleNumber(t *testing.T) {
+	v := unpickleNumbers(t, numbersInput, NumberAsString)
+
+	var dst struct {
+		A int
+		B int64
+		C *big.Int
+		D float32
+		E bool
+		F string
+	}
+	err := UnpackInto(&dst).From(map[interface{}]interface{}{
+		"A": v[0], "B": v[1], "C": v[2], "D": v[3], "E": v[4], "F": v[5],
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.A != 5 || dst.B != 5000000000 || dst.C.String() != "1180591620717411303424" ||
+		dst.D != 1.5 || !dst.E || dst.F != "-3" {
+		t.Fatalf("Got %+v", dst)
+	}
+
+	i, err := Int(v[1], nil)
+	if err != nil || i != 5000000000 {
+		t.Fatalf("Got %d %v", i, err)
+	}
+	f, err := Float(v[3], nil)
+	if err != nil || f != 1.5 {
+		t.Fatalf("Got %f %v", f, err)
+	}
+}
@@ -0,0 +1,123 @@
+package stalecucumber
+
+import "fmt"
+
+/*
+The kinds of argument that can follow an opcode in the pickle stream. The
+names follow the argument descriptors in Python's "pickletools" module.
+*/
+type opcodeArgument uint8
+
+const (
+	argNone opcodeArgument = iota
+	argUint1
+	argUint2
+	argInt4
+	argDecimalNLShort
+	argDecimalNLLong
+	argStringNL
+	argStringNLNoEscape
+	argStringNLNoEscapePair
+	argUnicodeNL
+	argFloatNL
+	argFloat8
+	argString1
+	argString4
+	argUnicode4
+	argLong1
+	argLong4
+)
+
+/*
+Describes a single opcode. The stack effect is described by the number of
+items popped from the stack and the number of items pushed onto it.
+Opcodes that consume a mark first pop everything above the topmost mark,
+then pop "Before" more items from below it.
+*/
+type opcodeInfo struct {
+	Name     string
+	Arg      opcodeArgument
+	Proto    int
+	Before   int
+	After    int
+	Mark     bool
+	PushMark bool
+}
+
+var opcodeInfos [256]*opcodeInfo
+
+func init() {
+	add := func(code uint8, info opcodeInfo) {
+		opcodeInfos[code] = &info
+	}
+
+	//Protocol 0
+	add(OPCODE_INT, opcodeInfo{Name: "INT", Arg: argDecimalNLShort, Proto: 0, After: 1})
+	add(OPCODE_LONG, opcodeInfo{Name: "LONG", Arg: argDecimalNLLong, Proto: 0, After: 1})
+	add(OPCODE_STRING, opcodeInfo{Name: "STRING", Arg: argStringNL, Proto: 0, After: 1})
+	add(OPCODE_NONE, opcodeInfo{Name: "NONE", Proto: 0, After: 1})
+	add(OPCODE_UNICODE, opcodeInfo{Name: "UNICODE", Arg: argUnicodeNL, Proto: 0, After: 1})
+	add(OPCODE_FLOAT, opcodeInfo{Name: "FLOAT", Arg: argFloatNL, Proto: 0, After: 1})
+	add(OPCODE_APPEND, opcodeInfo{Name: "APPEND", Proto: 0, Before: 2, After: 1})
+	add(OPCODE_LIST, opcodeInfo{Name: "LIST", Proto: 0, Mark: true, After: 1})
+	add(OPCODE_TUPLE, opcodeInfo{Name: "TUPLE", Proto: 0, Mark: true, After: 1})
+	add(OPCODE_DICT, opcodeInfo{Name: "DICT", Proto: 0, Mark: true, After: 1})
+	add(OPCODE_SETITEM, opcodeInfo{Name: "SETITEM", Proto: 0, Before: 3, After: 1})
+	add(OPCODE_POP, opcodeInfo{Name: "POP", Proto: 0, Before: 1})
+	add(OPCODE_DUP, opcodeInfo{Name: "DUP", Proto: 0, Before: 1, After: 2})
+	add(OPCODE_MARK, opcodeInfo{Name: "MARK", Proto: 0, PushMark: true})
+	add(OPCODE_GET, opcodeInfo{Name: "GET", Arg: argDecimalNLShort, Proto: 0, After: 1})
+	add(OPCODE_PUT, opcodeInfo{Name: "PUT", Arg: argDecimalNLShort, Proto: 0, Before: 1, After: 1})
+	add(OPCODE_GLOBAL, opcodeInfo{Name: "GLOBAL", Arg: argStringNLNoEscapePair, Proto: 0, After: 1})
+	add(OPCODE_REDUCE, opcodeInfo{Name: "REDUCE", Proto: 0, Before: 2, After: 1})
+	add(OPCODE_BUILD, opcodeInfo{Name: "BUILD", Proto: 0, Before: 2, After: 1})
+	add(OPCODE_INST, opcodeInfo{Name: "INST", Arg: argStringNLNoEscapePair, Proto: 0, Mark: true, After: 1})
+	add(OPCODE_STOP, opcodeInfo{Name: "STOP", Proto: 0, Before: 1})
+	add(OPCODE_PERSID, opcodeInfo{Name: "PERSID", Arg: argStringNLNoEscape, Proto: 0, After: 1})
+
+	//Protocol 1
+	add(OPCODE_BININT, opcodeInfo{Name: "BININT", Arg: argInt4, Proto: 1, After: 1})
+	add(OPCODE_BININT1, opcodeInfo{Name: "BININT1", Arg: argUint1, Proto: 1, After: 1})
+	add(OPCODE_BININT2, opcodeInfo{Name: "BININT2", Arg: argUint2, Proto: 1, After: 1})
+	add(OPCODE_BINSTRING, opcodeInfo{Name: "BINSTRING", Arg: argString4, Proto: 1, After: 1})
+	add(OPCODE_SHORT_BINSTRING, opcodeInfo{Name: "SHORT_BINSTRING", Arg: argString1, Proto: 1, After: 1})
+	add(OPCODE_BINUNICODE, opcodeInfo{Name: "BINUNICODE", Arg: argUnicode4, Proto: 1, After: 1})
+	add(OPCODE_BINFLOAT, opcodeInfo{Name: "BINFLOAT", Arg: argFloat8, Proto: 1, After: 1})
+	add(OPCODE_EMPTY_LIST, opcodeInfo{Name: "EMPTY_LIST", Proto: 1, After: 1})
+	add(OPCODE_APPENDS, opcodeInfo{Name: "APPENDS", Proto: 1, Mark: true, Before: 1, After: 1})
+	add(OPCODE_EMPTY_TUPLE, opcodeInfo{Name: "EMPTY_TUPLE", Proto: 1, After: 1})
+	add(OPCODE_EMPTY_DICT, opcodeInfo{Name: "EMPTY_DICT", Proto: 1, After: 1})
+	add(OPCODE_SETITEMS, opcodeInfo{Name: "SETITEMS", Proto: 1, Mark: true, Before: 1, After: 1})
+	add(OPCODE_POP_MARK, opcodeInfo{Name: "POP_MARK", Proto: 1, Mark: true})
+	add(OPCODE_BINGET, opcodeInfo{Name: "BINGET", Arg: argUint1, Proto: 1, After: 1})
+	add(OPCODE_LONG_BINGET, opcodeInfo{Name: "LONG_BINGET", Arg: argInt4, Proto: 1, After: 1})
+	add(OPCODE_BINPUT, opcodeInfo{Name: "BINPUT", Arg: argUint1, Proto: 1, Before: 1, After: 1})
+	add(OPCODE_LONG_BINPUT, opcodeInfo{Name: "LONG_BINPUT", Arg: argInt4, Proto: 1, Before: 1, After: 1})
+	add(OPCODE_OBJ, opcodeInfo{Name: "OBJ", Proto: 1, Mark: true, After: 1})
+	add(OPCODE_BINPERSID, opcodeInfo{Name: "BINPERSID", Proto: 1, Before: 1, After: 1})
+
+	//Protocol 2
+	add(OPCODE_LONG1, opcodeInfo{Name: "LONG1", Arg: argLong1, Proto: 2, After: 1})
+	add(OPCODE_LONG4, opcodeInfo{Name: "LONG4", Arg: argLong4, Proto: 2, After: 1})
+	add(OPCODE_NEWTRUE, opcodeInfo{Name: "NEWTRUE", Proto: 2, After: 1})
+	add(OPCODE_NEWFALSE, opcodeInfo{Name: "NEWFALSE", Proto: 2, After: 1})
+	add(OPCODE_TUPLE1, opcodeInfo{Name: "TUPLE1", Proto: 2, Before: 1, After: 1})
+	add(OPCODE_TUPLE2, opcodeInfo{Name: "TUPLE2", Proto: 2, Before: 2, After: 1})
+	add(OPCODE_TUPLE3, opcodeInfo{Name: "TUPLE3", Proto: 2, Before: 3, After: 1})
+	add(OPCODE_EXT1, opcodeInfo{Name: "EXT1", Arg: argUint1, Proto: 2, After: 1})
+	add(OPCODE_EXT2, opcodeInfo{Name: "EXT2", Arg: argUint2, Proto: 2, After: 1})
+	add(OPCODE_EXT4, opcodeInfo{Name: "EXT4", Arg: argInt4, Proto: 2, After: 1})
+	add(OPCODE_NEWOBJ, opcodeInfo{Name: "NEWOBJ", Proto: 2, Before: 2, After: 1})
+	add(OPCODE_PROTO, opcodeInfo{Name: "PROTO", Arg: argUint1, Proto: 2})
+}
+
+/*
+Returns the name of an opcode as used by Python's "pickletools" module.
+Unknown opcodes are returned as their hexadecimal value.
+*/
+func OpcodeName(code uint8) string {
+	if info := opcodeInfos[code]; info != nil {
+		return info.Name
+	}
+	return fmt.Sprintf("0x%02x", code)
+}
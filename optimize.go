@@ -0,0 +1,153 @@
+package stalecucumber
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+Rewrites the pickle read from r into w, dropping every PUT, BINPUT and
+LONG_BINPUT opcode whose memo entry is never read back by a GET, BINGET
+or LONG_BINGET. The memo entries that remain are renumbered from zero in
+the order they are stored. All other opcodes are copied unchanged, so the
+output unpickles to the same value as the input.
+
+This is the equivalent of "pickletools.optimize" from Python. Pickles
+written by protocol 0 and 1 usually store every object in the memo, so
+this can shrink them considerably.
+
+The complete pickle is held in memory while it is rewritten. Failures to
+decode the input are returned as an instance of DisassemblyError.
+*/
+func Optimize(w io.Writer, r io.Reader) error {
+	d := newCapturingDisassembler(r)
+
+	var program []Instruction
+	gets := make(map[int64]bool)
+	for {
+		ins, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch ins.Opcode {
+		case OPCODE_GET, OPCODE_BINGET, OPCODE_LONG_BINGET:
+			index, err := memoIndexArgument(ins)
+			if err != nil {
+				return err
+			}
+			gets[index] = true
+		}
+
+		program = append(program, ins)
+	}
+
+	bw := bufio.NewWriter(w)
+	renumbered := make(map[int64]int64)
+	var next int64
+	for _, ins := range program {
+		switch ins.Opcode {
+		case OPCODE_PUT, OPCODE_BINPUT, OPCODE_LONG_BINPUT:
+			index, err := memoIndexArgument(ins)
+			if err != nil {
+				return err
+			}
+			if !gets[index] {
+				continue
+			}
+			renumbered[index] = next
+			err = writeMemoOpcode(bw, false, ins.Opcode == OPCODE_PUT, next)
+			if err != nil {
+				return err
+			}
+			next++
+		case OPCODE_GET, OPCODE_BINGET, OPCODE_LONG_BINGET:
+			index, err := memoIndexArgument(ins)
+			if err != nil {
+				return err
+			}
+			replacement, ok := renumbered[index]
+			if !ok {
+				return DisassemblyError{
+					Offset: ins.Offset,
+					Opcode: ins.Opcode,
+					Name:   ins.Name,
+					Err:    fmt.Errorf("Memo index %d read before it was stored", index),
+				}
+			}
+			err = writeMemoOpcode(bw, true, ins.Opcode == OPCODE_GET, replacement)
+			if err != nil {
+				return err
+			}
+		default:
+			_, err := bw.Write(ins.raw)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+func memoIndexArgument(ins Instruction) (int64, error) {
+	switch v := ins.Arg.(type) {
+	case int64:
+		return v, nil
+	case bool:
+		//GET and PUT share the argument format of INT, which
+		//decodes "00" and "01" as booleans
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return 0, DisassemblyError{
+		Offset: ins.Offset,
+		Opcode: ins.Opcode,
+		Name:   ins.Name,
+		Err:    fmt.Errorf("Invalid memo index %v", ins.Arg),
+	}
+}
+
+/*
+Writes a memo opcode. Text opcodes are the protocol 0 forms GET and PUT,
+otherwise the shortest binary form able to hold the index is used.
+*/
+func writeMemoOpcode(w io.Writer, get bool, text bool, index int64) error {
+	if text {
+		code := OPCODE_PUT
+		if get {
+			code = OPCODE_GET
+		}
+		_, err := fmt.Fprintf(w, "%c%d\n", code, index)
+		return err
+	}
+
+	if index < 256 {
+		code := uint8(OPCODE_BINPUT)
+		if get {
+			code = OPCODE_BINGET
+		}
+		_, err := w.Write([]byte{code, uint8(index)})
+		return err
+	}
+
+	data := struct {
+		Opcode uint8
+		Index  int32
+	}{
+		OPCODE_LONG_BINPUT,
+		int32(index),
+	}
+	if get {
+		data.Opcode = OPCODE_LONG_BINGET
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
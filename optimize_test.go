@@ -0,0 +1,58 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testOptimize(t *testing.T, input string, expect string) {
+	buf := &bytes.Buffer{}
+	err := Optimize(buf, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != expect {
+		t.Fatalf("Got %q expected %q", buf.String(), expect)
+	}
+
+	before, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Unpickle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to unpickle optimized output:%v", err)
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("Optimized pickle unpickled to %v instead of %v", after, before)
+	}
+}
+
+func TestOptimizeProtocol0(t *testing.T) {
+	testOptimize(t,
+		"(lp0\n(lp1\nI1\naag1\naVa\np2\na(I2\nI3\ntp3\na.",
+		"(l(lp0\nI1\naag0\naVa\na(I2\nI3\nta.")
+}
+
+func TestOptimizeProtocol2(t *testing.T) {
+	testOptimize(t,
+		"\x80\x02]q\x00(]q\x01K\x01ah\x01X\x01\x00\x00\x00aq\x02K\x02K\x03\x86q\x03e.",
+		"\x80\x02](]q\x00K\x01ah\x00X\x01\x00\x00\x00aK\x02K\x03\x86e.")
+}
+
+func TestOptimizeLongBinGet(t *testing.T) {
+	testOptimize(t,
+		"\x80\x02]r\x00\x01\x00\x00(K\x01j\x00\x01\x00\x00e.",
+		"\x80\x02]q\x00(K\x01h\x00e.")
+}
+
+func TestOptimizePopMark(t *testing.T) {
+	//Every memo entry is read back, so nothing is dropped
+	testOptimize(t,
+		"((lp0\n(g0\ntp1\na00g1\n.",
+		"((lp0\n(g0\ntp1\na00g1\n.")
+}
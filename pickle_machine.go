@@ -44,6 +44,49 @@ Read a pickled dictionary into a structure
 
 	err := stalecucumber.UnpackInto(&mystruct).From(stalecucumber.Unpickle(somePickledData))
 
+The tag options described with NewPickler apply to unpacking as well.
+In addition the option "required" fails with an UnpackingError if the
+dict has no key for the field and "default=" gives the value of a field
+when the dict has no key for it, such as `pickle:"port,default=8080"`.
+The option "string" accepts the number or bool in a Python string.
+A Python set or frozenset can be unpacked into a map[T]struct{}, a
+map[T]bool or a slice []T. The items are converted the same way as the
+keys of a dict. The option "set" pickles such a map as a Python set.
+
+The option "remain" on a field such as map[string]interface{} collects
+every key of the dict that does not belong to any other field, so that
+keys are not lost when the struct is pickled again.
+
+Read a pickled tuple into a structure or an array
+	pickle.dumps((3, "Fizz", 1.5))
+	---
+	var somePickledData io.Reader
+	mystruct := struct{
+		_ struct{} `pickle:",tuple"`
+		ID int
+		Name string
+		Score float64}{}
+
+	err := stalecucumber.UnpackInto(&mystruct).From(stalecucumber.Unpickle(somePickledData))
+
+A Python tuple or list fills the exported fields of a struct in the order
+they are declared when the struct has a blank field with the "tuple"
+option. It can also fill an array such as [3]float64. The number of items
+must be the same as the number of fields or elements.
+
+Read a pickled dictionary into a map
+	pickle.dumps({1:"one",2:"two"})
+	---
+	var somePickledData io.Reader
+	var mymap map[int]string
+
+	err := stalecucumber.UnpackInto(&mymap).From(stalecucumber.Unpickle(somePickledData))
+
+Keys and values of a map are unpacked the same way as any other value. A Python
+tuple key can be unpacked into a struct key with one exported field for
+each item, or into an array. A Python string key can be unpacked into a
+key that implements encoding.TextUnmarshaler.
+
 Pickle a struct
 
 	buf := new(bytes.Buffer)
@@ -115,6 +158,51 @@ and "cPickle" modules in Python write using protocol 0. Protocol 0
 requires much more space to represent the same values and is much
 slower to parse.
 
+Disassembling pickles
+
+When a pickle fails to load, Disassemble writes a listing of it in the same
+format as Python's "pickletools.dis". Each line gives the byte offset, the
+opcode, its decoded argument and the nesting of marks. Decoding stops at the
+first malformed opcode and the offset is reported in a DisassemblyError. The
+command "cmd/pickledis" wraps this function.
+
+To walk the opcodes programmatically use NewDisassembler.
+
+Optimize rewrites a pickle without the memo stores that are never read back,
+the same as Python's "pickletools.optimize".
+
+Transcode rewrites a pickle using the opcodes of another protocol without
+unpickling it. Tuples, globals, memo references and everything else in the
+pickle are kept as they are.
+
+Observing opcodes
+
+An Unpickler with a Visitor set calls the visitor with every opcode, its
+decoded argument and the depth of the stack before the opcode executes.
+This can be used to gather statistics about pickles or to reject them
+part way through by returning an error.
+
+	u := stalecucumber.NewUnpickler(reader)
+	u.Visitor = stalecucumber.OpcodeVisitorFunc(func(ins stalecucumber.Instruction) error {
+		if ins.Opcode == stalecucumber.OPCODE_GLOBAL {
+			globals[ins.Arg.(string)]++
+		}
+		return nil
+	})
+	v, err := u.Unpickle()
+
+Custom containers
+
+An Unpickler with a Builder set creates every list, dict, tuple and set
+with it instead of using the types listed below. This allows a dict to
+keep the order of its keys or a tuple to be told apart from a list.
+DefaultContainerBuilder creates the usual types and can be embedded to
+replace only some of them.
+
+	u := stalecucumber.NewUnpickler(reader)
+	u.Builder = myOrderedDictBuilder{}
+	v, err := u.Unpickle()
+
 Unsupported Opcodes
 
 The pickle format is incredibly flexible and as a result has some
@@ -179,6 +267,10 @@ import "io"
 import "bytes"
 import "encoding/binary"
 import "fmt"
+import "strings"
+import "math/big"
+import "reflect"
+import "unicode/utf8"
 
 var ErrOpcodeStopped = errors.New("STOP opcode found")
 var ErrStackTooSmall = errors.New("Stack is too small to perform requested operation")
@@ -215,6 +307,20 @@ Types conversion Python types to Go types is performed as followed
 The following values are converted from Python to the Go types
 	True & False -> bool
 	None -> stalecucumber.PickleNone, sets pointers to nil
+	bytes -> stalecucumber.PickleBytes, from Python 3 or an Unpickler with Encoding "bytes"
+	bytearray -> stalecucumber.PickleByteArray
+	set -> stalecucumber.PickleSet
+	frozenset -> stalecucumber.PickleFrozenSet
+	classes and functions -> stalecucumber.PythonGlobal
+	instances of classes -> *stalecucumber.PythonObject
+
+Tuples used as the keys of a dict or the items of a set are converted to
+arrays, such as [2]interface{}, since a Go slice can not be a map key.
+Frozensets used this way are converted to stalecucumber.PickleFrozenSetKey.
+
+An Unpickler with NumberMode set converts Python numbers to other Go
+types. For example NumberLongAsInt64 returns int64 for any Python long
+that fits.
 
 Helper Functions
 
@@ -224,15 +330,36 @@ fails it does nothing and returns that error. Otherwise it attempts to
 convert to the appropriate type. If type conversion fails it returns an error
 
 	String - string from Python string or unicode
+	Bytes - []byte from Python string, kept as PickleBytes or not
 	Int - int64 from Python int or long
 	Bool - bool from Python True or False
 	Big - *big.Int from Python long
 	ListOrTuple - []interface{} from Python Tuple or List
 	Float - float64 from Python float
 	Dict - map[interface{}]interface{} from Python dictionary
+	Set - map[interface{}]bool from Python set or frozenset
 	DictString -
 		map[string]interface{} from Python dictionary.
 		Keys must all be of type unicode or string.
+	Normalize -
+		the same value with every dict of string keys as a
+		map[string]interface{}, *big.Int as int64 if it fits
+		and bytes as []byte, at every level.
+
+Python 2 Strings
+
+Python 2 has no separate type for text. Unpickle places the bytes of a
+Python 2 string into a Go string without decoding them. To decode them
+with a known encoding, or to tell them apart from unicode, use an
+Unpickler with Encoding set.
+
+Without an encoding, an escape such as "\xe9" in a protocol 0 string is
+the UTF-8 encoding of U+00E9, as it always has been. With Encoding set
+it is the single byte 0xe9, which is decoded like any other byte.
+
+	u := stalecucumber.NewUnpickler(reader)
+	u.Encoding = "latin-1"
+	v, err := u.Unpickle()
 
 Unpacking into structures
 
@@ -249,6 +376,12 @@ the first character of the key is not uppercase, it is uppercased. If a field
 matching that name is found, the value in the python dictionary is unpacked
 into the value of the field within the structure.
 
+Setting "NameMapper" on the return value of UnpackInto changes how keys are
+matched to fields without a name in their tag. SnakeCaseNameMapper matches
+the key "user_id" to the field "UserID" and CaseInsensitiveNameMapper
+ignores case. Set the same NameMapper on a Pickler to write the keys that
+it expects.
+
 A list of python dictionaries can be unpickled into a slice of structures in
 Go.
 
@@ -259,12 +392,54 @@ A nested python dictionary is unpickled into nested structures in Go. If a
 field is of type map[interface{}]interface{} it is of course unpacked into that
 as well.
 
+The fields of an embedded struct are filled from a nested dict with the
+name of the struct, or from keys of the outer dict that match fields
+promoted from it.
+
 By default UnpackInto skips any missing fields and fails if a field's
 type is not compatible with the object's type.
 
 This behavior can be changed by setting "AllowMissingFields" and
 "AllowMismatchedFields" on the return value of UnpackInto before calling
-From.
+From. When "AllowMismatchedFields" zeroes a field it calls "OnMismatch"
+with the error, if it is set, so that mismatches can be logged.
+
+Each UnpackingError has a Path giving where in the source it happened,
+such as `orders[3].items["sku"].price`. By default From stops at the
+first error. Setting "CollectErrors" keeps going past every
+UnpackingError and returns all of them together as UnpackingErrors.
+
+Python None sets a pointer to nil. Any type that implements sql.Scanner,
+such as sql.NullString or sql.NullInt64, can also be unpacked from None
+or from a value, so that a pointer is not needed for optional values. A
+list or dict is unpacked into such a type, such as a slice with a Scan
+method, by the usual rules instead. By default None can not be unpacked
+into any other type. Setting
+"ZeroOnNone" sets those to their zero value instead.
+
+A Python str, bytes or bytearray can be unpacked into a []byte, a [N]byte
+of the same length, a bytes.Buffer, or an io.Reader or io.Writer field,
+which is set to a *bytes.Reader or *bytes.Buffer. Setting
+"StreamIntoWriters" writes the data into an io.Writer field that is
+already set, such as an *os.File, instead of replacing it.
+
+An instance of a Python class is unpacked like a dict of its attributes.
+To unpack it into a field that is an interface, set "Classes" to a
+ClassRegistry that gives the Go type to create for each Python class.
+
+Setting "Coerce" allows values to be unpacked into fields of a different
+kind, such as the Python float 3.0 into an int or the string "42" into a
+float64. A value that would lose precision or overflow fails with
+ErrTargetTypeOverflow. If "AllowMismatchedFields" is set the field is
+zeroed instead, the same as any other mismatch. See Coercion.
+
+Setting "Normalize" converts every value placed in an interface{}, such as
+the values of a map[string]interface{}, the same as the Normalize helper.
+This gives a tree that "encoding/json" can marshal.
+
+Conversions that UnpackInto does not know about, such as a Python float of
+seconds since the epoch into a time.Time, can be added by setting
+"DecodeHooks". See DecodeHooks.
 
 */
 func Unpickle(reader io.Reader) (interface{}, error) {
@@ -272,28 +447,9 @@ func Unpickle(reader io.Reader) (interface{}, error) {
 }
 
 func UnpickleWithResolver(reader io.Reader, resolver PythonResolver) (interface{}, error){
-	var pm PickleMachine
-	pm.buf = &bytes.Buffer{}
-	pm.Reader = reader
-	pm.lastMark = -1
-	if resolver == nil {
-		pm.resolver = PythonBuiltinResolver{} 
-	} else {
-		pm.resolver = resolver
-	}
-	//Pre allocate a small stack
-	pm.Stack = make([]interface{}, 0, 16)
-
-	err := (&pm).execute()
-	if err != ErrOpcodeStopped {
-		return nil, pm.error(err)
-	}
-
-	if len(pm.Stack) == 0 {
-		return nil, ErrNoResult
-	}
-
-	return pm.Stack[0], nil
+	u := NewUnpickler(reader)
+	u.Resolver = resolver
+	return u.Unpickle()
 }
 
 var jumpList = buildEmptyJumpList()
@@ -304,14 +460,23 @@ func init() {
 
 /*
 This type is returned whenever Unpickle encounters an error in pickled data.
+
+Offset is the byte offset in the input of the opcode that failed. StackTop
+holds up to the top three items of the stack at the time of the failure,
+with the topmost item last.
 */
 type PickleMachineError struct {
-	Err       error
-	StackSize int
-	MemoSize  int
-	Opcode    uint8
+	Err        error
+	StackSize  int
+	MemoSize   int
+	Opcode     uint8
+	OpcodeName string
+	Offset     int64
+	StackTop   []interface{}
 }
 
+const pickleMachineErrorStackTop = 3
+
 /*
 This struct is current exposed but not useful. It is likely to be hidden
 in the near future.
@@ -323,7 +488,17 @@ type PickleMachine struct {
 	
 	resolver PythonResolver
 	currentOpcode uint8
+	opcodeOffset  int64
+	input         *countingReader
 	buf           *bytes.Buffer
+	visitor       OpcodeVisitor
+	decoder       *Disassembler
+	decoding      *stringDecoder
+	fixImports    bool
+	classFinder   func(module string, name string) (interface{}, error)
+	numbers       NumberMode
+	builder       ContainerBuilder
+	argument      bytes.Reader
 	lastMark      int
 
 	memoBuffer               [16]memoBufferElement
@@ -337,20 +512,101 @@ type memoBufferElement struct {
 }
 
 func (pme PickleMachineError) Error() string {
-	return fmt.Sprintf("Pickle Machine failed on opcode:0x%x. Stack size:%d. Memo size:%d. Cause:%v", pme.Opcode, pme.StackSize, pme.MemoSize, pme.Err)
+	top := make([]string, len(pme.StackTop))
+	for i, v := range pme.StackTop {
+		top[i] = describeForError(v)
+	}
+
+	return fmt.Sprintf("Pickle Machine failed on opcode:0x%x(%s) at offset %d. Stack size:%d. Memo size:%d. Stack top:[%s]. Cause:%v",
+		pme.Opcode,
+		pme.OpcodeName,
+		pme.Offset,
+		pme.StackSize,
+		pme.MemoSize,
+		strings.Join(top, ", "),
+		pme.Err)
+}
+
+func (pme PickleMachineError) Unwrap() error {
+	return pme.Err
+}
+
+const maxErrorValueLen = 64
+
+/*
+Describes a value on the stack for an error message without formatting
+all of it. Containers, which may hold millions of items, are described
+by their type and length. Strings are cut before they are formatted.
+*/
+func describeForError(v interface{}) string {
+	switch v := v.(type) {
+	case nil, PickleNone, PickleMark, bool, int64, float32, float64, *big.Float:
+		return truncateForError(fmt.Sprintf("(%T)%v", v, v))
+	case *big.Int:
+		//Longs can be as large as the pickle
+		if v.BitLen() > 4*maxErrorValueLen {
+			return fmt.Sprintf("(%T)bits=%d", v, v.BitLen())
+		}
+		return truncateForError(fmt.Sprintf("(%T)%v", v, v))
+	case string:
+		return fmt.Sprintf("(%T)%s", v, truncateForError(v))
+	case PickleNumber:
+		return fmt.Sprintf("(%T)%s", v, truncateForError(string(v)))
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return fmt.Sprintf("(%T)len=%d", v, rv.Len())
+	}
+	return fmt.Sprintf("(%T)", v)
+}
+
+/*
+Cuts a string to at most maxErrorValueLen bytes, on a rune boundary.
+*/
+func truncateForError(s string) string {
+	if len(s) <= maxErrorValueLen {
+		return s
+	}
+	end := maxErrorValueLen
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
 }
 
 func (pm *PickleMachine) error(src error) error {
+	top := len(pm.Stack) - pickleMachineErrorStackTop
+	if top < 0 {
+		top = 0
+	}
+
 	return PickleMachineError{
-		StackSize: len(pm.Stack),
-		MemoSize:  len(pm.Memo),
-		Err:       src,
-		Opcode:    pm.currentOpcode,
+		StackSize:  len(pm.Stack),
+		MemoSize:   len(pm.Memo),
+		Err:        src,
+		Opcode:     pm.currentOpcode,
+		OpcodeName: OpcodeName(pm.currentOpcode),
+		Offset:     pm.opcodeOffset,
+		StackTop:   append([]interface{}(nil), pm.Stack[top:]...),
 	}
 }
 
 func (pm *PickleMachine) execute() error {
+	if pm.visitor != nil {
+		for {
+			err := pm.visitOpcode()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	for {
+		if pm.input != nil {
+			pm.opcodeOffset = pm.input.n
+		}
 		err := binary.Read(pm.Reader, binary.BigEndian, &pm.currentOpcode)
 		if err != nil {
 			return err
@@ -367,7 +623,7 @@ func (pm *PickleMachine) execute() error {
 func (pm *PickleMachine) flushMemoBuffer(vIndex int64, v interface{}) {
 	//Extend the memo until it is large enough
 	if pm.memoBufferMaxDestination >= int64(len(pm.Memo)) {
-		replacement := make([]interface{}, pm.memoBufferMaxDestination<<1)
+		replacement := make([]interface{}, (pm.memoBufferMaxDestination+1)<<1)
 		copy(replacement, pm.Memo)
 		pm.Memo = replacement
 	}
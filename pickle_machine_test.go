@@ -1,6 +1,7 @@
 package stalecucumber
 
 import (
+	"errors"
 	"io"
 	"bytes"
 	"fmt"
@@ -122,6 +123,12 @@ func TestProtocol0String(t *testing.T) {
 		"String with embedded\nnewline and embedded quote ' and embedded doublequote \".")
 }
 
+func TestProtocol0StringEscapes(t *testing.T) {
+	//Escapes are the UTF-8 encoding of the rune with that value
+	testString(t, "S'\\xe9t'\np0\n.", "\u00e9t")
+	testString(t, "S'\\x00\\x7f\\x80'\np0\n.", "\x00\x7f\u0080")
+}
+
 func testBigIntFromString(t *testing.T, input string, expectStr string) {
 	var expect big.Int
 
@@ -210,6 +217,21 @@ func TestProtocol1Get(t *testing.T) {
 	testList(t, "]q\x00(U\nhydrogen18q\x01h\x01e.", []interface{}{"hydrogen18", "hydrogen18"})
 }
 
+func TestMemoGetFirstIndex(t *testing.T) {
+	//Reading memo index 0 while the memo is still empty
+	//flushes the memo buffer into a memo of length 0
+	inputs := []string{"(lp0\ng0\na.", "\x80\x02]q\x00h\x00a."}
+	for _, input := range inputs {
+		result, err := ListOrTuple(Unpickle(strings.NewReader(input)))
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("%q: got %v", input, result)
+		}
+	}
+}
+
 func TestProtocol0Dict(t *testing.T) {
 
 	{
@@ -294,6 +316,75 @@ func TestProtocol2Set(t *testing.T) {
 	}
 }
 
+func TestFrozenSetKeys(t *testing.T) {
+	// pickle.dumps(set([frozenset([1])]), 2)
+	set, err := Set(Unpickle(strings.NewReader("\x80\x02c__builtin__\nset\nq\x00]q\x01c__builtin__\nfrozenset\nq\x02]q\x03K\x01a\x85q\x04Rq\x05a\x85q\x06Rq\x07.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 {
+		t.Fatalf("Got %v", set)
+	}
+	for item := range set {
+		key, ok := item.(PickleFrozenSetKey)
+		if !ok {
+			t.Fatalf("Got %T", item)
+		}
+		if !reflect.DeepEqual(PickleFrozenSet{int64(1): true}, key.Set()) {
+			t.Fatalf("Got %v", key.Set())
+		}
+	}
+
+	// pickle.dumps({frozenset([1, 2]): 1}, 2) and
+	// pickle.dumps({frozenset([1]): 1}, 0)
+	inputs := []string{
+		"\x80\x02}q\x00c__builtin__\nfrozenset\nq\x01]q\x02(K\x01K\x02e\x85q\x03Rq\x04K\x01s.",
+		"(dp0\nc__builtin__\nfrozenset\np1\n((lp2\nI1\natp3\nRp4\nI1\ns.",
+	}
+	for _, input := range inputs {
+		dict, err := Dict(Unpickle(strings.NewReader(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dict) != 1 {
+			t.Fatalf("Got %v", dict)
+		}
+
+		//Keys are pickled as frozensets again
+		buf := &bytes.Buffer{}
+		_, err = NewPickler(buf).Pickle(dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := Dict(Unpickle(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dict, again) {
+			t.Fatalf("Got %v expected %v", again, dict)
+		}
+	}
+
+	//Equal frozensets are the same key
+	a, err := hashableKey(PickleFrozenSet{int64(2): true, "b": true, int64(1): true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hashableKey(PickleFrozenSet{"b": true, int64(1): true, int64(2): true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+
+	//Dicts can not be keys
+	_, err = Unpickle(strings.NewReader("(dp0\n(dp1\nI1\ns."))
+	if _, ok := errors.Unwrap(err).(UnhashableKeyError); !ok {
+		t.Fatalf("Got %v", err)
+	}
+}
+
 func TestProtocol0GarbageReduce(t *testing.T){
 	reader := strings.NewReader("S'foo'\nS'bar'\nR.")
 	/**
@@ -333,22 +424,62 @@ func TestProtocol0Bytearray(t *testing.T){
 		t.Error("Expected result value but got nil")
 	}
 
-	buffer, ok := result.(*strings.Reader)	
+	actual, ok := result.(PickleByteArray)
 	if !ok{
-		t.Errorf("Expected byte buffer but got %T", result)
+		t.Errorf("Expected bytearray but got %T", result)
 	}
 
 	const expected = `abc123`
-	actual := bytes.NewBuffer(nil)
-	_, err = io.Copy(actual, buffer)
-	if err != nil {
-		t.Fatal(err)
+	if string(actual) != expected {
+		t.Errorf("Expected %q but got %q", expected, actual)
 	}
-	
-	if actual.String() != expected {
-		t.Errorf("Expected %q but got %q", expected, actual.String())
+
+}
+
+func TestProtocol2Bytes(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect PickleBytes
+	}{
+		{"\x80\x02c__builtin__\nbytes\nq\x00)Rq\x01.", ""},
+		{"\x80\x02cbuiltins\nbytes\nq\x00)Rq\x01.", ""},
+		{"\x80\x02c_codecs\nencode\nq\x00X\x03\x00\x00\x00a\xc3\xbfq\x01X\x06\x00\x00\x00latin1q\x02\x86q\x03Rq\x04.", "a\xff"},
 	}
 
+	for _, c := range cases {
+		result, err := Unpickle(strings.NewReader(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expect {
+			t.Errorf("Expected %q but got (%T)%q", c.expect, result, result)
+		}
+	}
+}
+
+func TestProtocol2Bytearray(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{"\x80\x02cbuiltins\nbytearray\nq\x00)Rq\x01.", ""},
+		{"\x80\x02c__builtin__\nbytearray\nq\x00c_codecs\nencode\nq\x01X\x03\x00\x00\x00a\xc3\xbfq\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07.", "a\xff"},
+		{"\x80\x02c__builtin__\nbytearray\nq\x00X\x03\x00\x00\x00a\xc3\xbfq\x01U\x07latin-1q\x02\x86q\x03Rq\x04.", "a\xff"},
+	}
+
+	for _, c := range cases {
+		result, err := Unpickle(strings.NewReader(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, ok := result.(PickleByteArray)
+		if !ok {
+			t.Fatalf("Expected bytearray but got %T", result)
+		}
+		if string(actual) != c.expect {
+			t.Errorf("Expected %q but got %q", c.expect, actual)
+		}
+	}
 }
 
 func TestProtocol1Dict(t *testing.T) {
@@ -667,4 +798,59 @@ func TestProtocol0InstAndBuild(t *testing.T){
 	if !ok || bar != expectedBar {
 		t.Fatalf("Expected %v but got %v", expectedBar, bar)
 	}
-}
\ No newline at end of file
+}
+
+func TestPickleMachineErrorOffset(t *testing.T) {
+	_, err := Unpickle(strings.NewReader("\x80\x02]q\x00(K\x01K\x02S'x\ne."))
+
+	pme, ok := err.(PickleMachineError)
+	if !ok {
+		t.Fatalf("Expected %T but got (%T)%v", pme, err, err)
+	}
+
+	const expectOffset = 10
+	if pme.Offset != expectOffset {
+		t.Errorf("Expected offset %d but got %d", expectOffset, pme.Offset)
+	}
+
+	if pme.OpcodeName != "STRING" {
+		t.Errorf("Expected opcode name STRING but got %q", pme.OpcodeName)
+	}
+
+	expectTop := []interface{}{PickleMark{}, int64(1), int64(2)}
+	if !reflect.DeepEqual(pme.StackTop, expectTop) {
+		t.Errorf("Expected stack top %v but got %v", expectTop, pme.StackTop)
+	}
+
+	_, err = Unpickle(strings.NewReader("S'foo'\nS'bar'\nR."))
+	var unreducible UnreducibleValueError
+	if !errors.As(err, &unreducible) {
+		t.Errorf("Expected error to wrap %T but got %v", unreducible, err)
+	}
+
+	_, err = Unpickle(strings.NewReader("\x80\x02K"))
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected error to wrap %v but got %v", io.EOF, err)
+	}
+}
+
+func TestPickleMachineErrorLargeValues(t *testing.T) {
+	pme := PickleMachineError{
+		StackTop: []interface{}{
+			make([]interface{}, 2000000),
+			strings.Repeat("\u20ac", 100),
+			new(big.Int).Lsh(big.NewInt(1), 1000),
+		},
+	}
+
+	msg := pme.Error()
+	if !strings.Contains(msg, "([]interface {})len=2000000") {
+		t.Errorf("List was not summarized: %s", msg)
+	}
+	if !strings.Contains(msg, "(*big.Int)bits=1001") {
+		t.Errorf("Long was not summarized: %s", msg)
+	}
+	if !utf8.ValidString(msg) {
+		t.Errorf("String was not cut on a rune boundary: %q", msg)
+	}
+}
@@ -6,13 +6,18 @@ import "errors"
 import "encoding/binary"
 import "fmt"
 import "math/big"
+import "strings"
+import "database/sql/driver"
 
 type pickleProxy interface {
-	WriteTo(io.Writer) (int, error)
+	writeTo(io.Writer) (int, error)
 }
 
 type Pickler struct {
-	W io.Writer
+	W             io.Writer
+	PythonVersion int
+	NameMapper    NameMapper
+	Classes       *ClassRegistry
 
 	program []pickleProxy
 }
@@ -29,6 +34,21 @@ Failures return the underlying error or an instance of PicklingError.
 Data is always written using Pickle Protocol 2. This format is
 compatible with Python 2.3 and all newer version.
 
+PythonVersion is the major version of Python that reads the pickle. It
+decides the module names of any Python globals that are written. If it is
+less than 3, which is the default, the Python 2 names are used. Python 3
+loads these names as well unless "fix_imports" is turned off. If it is 3
+or more the Python 3 names are used.
+
+NameMapper gives the keys that struct fields without a name in their tag
+are pickled as. If it is nil the Go name of each field is used. See
+NameMapper.
+
+Classes gives the Python classes that structs are pickled as instances
+of. A struct of a type registered in it is written as an instance of the
+class, with the dict its fields are pickled as for its state. See
+ClassRegistry.
+
 Type Conversions
 
 Type conversion from Go types to Python types is as follows
@@ -36,11 +56,18 @@ Type conversion from Go types to Python types is as follows
 	uint8,uint16,int8,int16,int32 -> Python int
 	int,int64,uint,uint64 -> Python int if it fits, otherwise Python Long
 	string -> Python unicode
+	[]byte, PickleBytes -> Python bytes, which is str in Python 2
+	PickleByteArray -> Python bytearray
+	PickleSet, PickleFrozenSet -> Python set and frozenset
+	PythonGlobal -> a reference to the Python global
+	PythonObject -> an instance of its class
 	slices, arrays -> Python list
 	maps -> Python dict
 	bool -> Python True and False
 	big.Int -> Python Long
 	struct -> Python dict
+	sql.NullString and the other sql.Null types -> Python None if they
+	are not valid, otherwise their value
 
 Structs are pickled using their field names unless a tag is present on the
 field specifying the name. For example
@@ -56,6 +83,30 @@ and "meow".
 Embedded structs are marshalled as a nested dictionary. Exported types
 are never pickled.
 
+The name in a tag can be followed by options separated by commas, the same
+as "encoding/json". The options used when pickling are
+
+	"-" as the whole tag, which never pickles the field
+	omitempty, which leaves out the field if it is false, zero, nil or empty
+	inline, which pickles the fields of an embedded struct as if they
+	belonged to the outer struct. This is always done for embedded
+	structs that are not exported.
+	string, which pickles a number or bool as a Python string
+	set, on a map such as map[T]struct{} or map[T]bool, which pickles the
+	keys of the map as a Python set. Keys that map to false are left out
+	remain, on a map such as map[string]interface{}, which pickles each
+	key and value of the map into the dict of the struct, unless the
+	key belongs to another field
+
+A struct with a blank field tagged with the "tuple" option is pickled as
+a Python tuple of its exported fields, in the order they are declared.
+
+	type Point struct {
+		_ struct{} `pickle:",tuple"`
+		X float64
+		Y float64
+	}
+
 Pickling Tuples
 
 There is no equivalent type to Python's tuples in Go. You may not need
@@ -70,7 +121,8 @@ is a Python list or a Python tuple.
 
 If you really need to write tuples, call NewTuple and pass the data
 in as the arguments. This special type exists to inform stalecucumber.Pickle that
-a tuple should be pickled.
+a tuple should be pickled. Structs that are tuples, as described above,
+are also pickled as tuples.
 
 */
 func NewPickler(writer io.Writer) *Pickler {
@@ -102,7 +154,7 @@ func (p *Pickler) writeProgram() (n int, err error) {
 	}
 	var m int
 	for _, proxy := range p.program {
-		m, err = proxy.WriteTo(p.W)
+		m, err = proxy.writeTo(p.W)
 		if err != nil {
 			return
 		}
@@ -140,6 +192,20 @@ func (p *Pickler) dump(input interface{}) error {
 		return nil
 	}
 
+	//The sql.Null types pickle their value, which
+	//is nil if they are not valid
+	if valuer, ok := input.(driver.Valuer); ok && isSQLNullType(reflect.TypeOf(input)) {
+		if rv := reflect.ValueOf(input); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			p.pushOpcode(OPCODE_NONE)
+			return nil
+		}
+		value, err := valuer.Value()
+		if err != nil {
+			return PicklingError{V: input, Err: err}
+		}
+		return p.dump(value)
+	}
+
 	switch input := input.(type) {
 	case int:
 		if input <= BININT_MAX && input >= BININT_MIN {
@@ -203,6 +269,40 @@ func (p *Pickler) dump(input interface{}) error {
 	case string:
 		p.dumpString(input)
 		return nil
+	case []byte:
+		p.dumpBytes(input)
+		return nil
+	case PickleBytes:
+		p.dumpBytes([]byte(input))
+		return nil
+	case PickleByteArray:
+		p.dumpGlobal("builtins", "bytearray")
+		if len(input) == 0 {
+			p.pushOpcode(OPCODE_EMPTY_TUPLE)
+		} else {
+			p.dumpBytes(input)
+			p.pushOpcode(OPCODE_TUPLE1)
+		}
+		p.pushOpcode(OPCODE_REDUCE)
+		return nil
+	case PythonGlobal:
+		p.dumpGlobal(input.Module, input.Name)
+		return nil
+	case PythonObject:
+		err := p.dumpNewObject(input.Class, input.Args)
+		if err != nil || input.State == nil {
+			return err
+		}
+		err = p.dump(input.State)
+		if err != nil {
+			return err
+		}
+		p.pushOpcode(OPCODE_BUILD)
+		return nil
+	case PickleSet:
+		return p.dumpSet("set", reflect.ValueOf(input))
+	case PickleFrozenSet:
+		return p.dumpSet("frozenset", reflect.ValueOf(input))
 	case bool:
 		p.dumpBool(input)
 		return nil
@@ -263,7 +363,7 @@ func (p *Pickler) dump(input interface{}) error {
 
 		keys := v.MapKeys()
 		for _, key := range keys {
-			err := p.dump(key.Interface())
+			err := p.dumpKey(key.Interface())
 			if err != nil {
 				return err
 			}
@@ -285,12 +385,59 @@ func (p *Pickler) dump(input interface{}) error {
 		p.pushOpcode(OPCODE_APPENDS)
 		return nil
 	case reflect.Struct:
-		return p.dumpStruct(v, false)
+		if class, ok := p.Classes.pythonClass(v.Type()); ok {
+			err := p.dumpNewObject(class, nil)
+			if err != nil {
+				return err
+			}
+			err = p.dumpStruct(v)
+			if err != nil {
+				return err
+			}
+			p.pushOpcode(OPCODE_BUILD)
+			return nil
+		}
+		if isTupleStruct(v.Type()) {
+			fields := tupleFields(v)
+			tuple := make(PickleTuple, len(fields))
+			for i, field := range fields {
+				tuple[i] = field.Interface()
+			}
+			return p.dump(tuple)
+		}
+		return p.dumpStruct(v)
 	}
 
 	return PicklingError{V: input, Err: ErrTypeNotPickleable}
 }
 
+/*
+Reports if a type is one of the sql.Null types, such as sql.NullString
+or sql.Null[T]. Other types that implement driver.Valuer are pickled
+the same as any other value.
+*/
+func isSQLNullType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null")
+}
+
+/*
+Pickles a new instance of a Python class, the same as Python does for
+protocol 2. The state of the instance is pickled next, followed by a
+BUILD opcode.
+*/
+func (p *Pickler) dumpNewObject(class PythonGlobal, args []interface{}) error {
+	p.dumpGlobal(class.Module, class.Name)
+	err := p.dump(PickleTuple(args))
+	if err != nil {
+		return err
+	}
+	p.pushOpcode(OPCODE_NEWOBJ)
+	return nil
+}
+
 func (p *Pickler) dumpBool(v bool) {
 	if v {
 		p.pushOpcode(OPCODE_NEWTRUE)
@@ -299,49 +446,92 @@ func (p *Pickler) dumpBool(v bool) {
 	}
 }
 
-func (p *Pickler) dumpStruct(v reflect.Value,  nested bool) error {
-	vType := v.Type()
-        if !nested {
-		p.pushOpcode(OPCODE_EMPTY_DICT)
-		p.pushOpcode(OPCODE_MARK)
-	}
+func (p *Pickler) dumpStruct(v reflect.Value) error {
+	p.pushOpcode(OPCODE_EMPTY_DICT)
+	p.pushOpcode(OPCODE_MARK)
+
+	fields, remain := structFields(v.Type(), p.NameMapper)
+	for _, field := range fields {
+		fieldValue := v.FieldByIndex(field.index)
+		if field.omitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		p.dumpString(field.name)
 
-	for i := 0; i != v.NumField(); i++ {
-		field := vType.Field(i)
-		//Never attempt to write
-		//unexported names
-		if len(field.PkgPath) != 0 {
-                        //Check for embedded field, which can possibly be dumped 
-                        if field.Anonymous {
-                          err := p.dumpStruct(v.Field(i), true)
-                          if err != nil { 
-                            return err
-                          }
-                        } 
-                        continue
+		if field.asSet && fieldValue.Kind() == reflect.Map {
+			err := p.dumpSet("set", fieldValue)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		//Prefer the tagged name of the
-		//field, fall back to fields actual name
-		fieldKey := field.Tag.Get(PICKLE_TAG)
-		if len(fieldKey) == 0 {
-			fieldKey = field.Name
+		if field.asString {
+			if text, ok := formatText(fieldValue); ok {
+				p.dumpString(text)
+				continue
+			}
 		}
-		p.dumpString(fieldKey)
 
-		fieldValue := v.Field(i)
 		err := p.dump(fieldValue.Interface())
 		if err != nil {
 			return err
 		}
+	}
 
+	if remain != nil {
+		err := p.dumpRemain(v.FieldByIndex(remain), fields)
+		if err != nil {
+			return err
+		}
 	}
-	if !nested {
-		p.pushOpcode(OPCODE_SETITEMS)
+
+	p.pushOpcode(OPCODE_SETITEMS)
+	return nil
+}
+
+/*
+Writes the keys and values of the field of a struct with the "remain"
+option into the dict of the struct. Keys that belong to another field
+are left out.
+*/
+func (p *Pickler) dumpRemain(remain reflect.Value, fields []structField) error {
+	for _, key := range remain.MapKeys() {
+		if name, ok := key.Interface().(string); ok && findStructFieldByName(fields, name) != -1 {
+			continue
+		}
+
+		err := p.dump(key.Interface())
+		if err != nil {
+			return err
+		}
+		err = p.dump(remain.MapIndex(key).Interface())
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+/*
+Formats a number or bool as text for a field with the "string" option.
+Returns false for other values, which are pickled as usual.
+*/
+func formatText(v reflect.Value) (string, bool) {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), true
+	}
+	return "", false
+}
+
 func (p *Pickler) pushProxy(proxy pickleProxy) {
 	p.program = append(p.program, proxy)
 }
@@ -352,7 +542,7 @@ func (p *Pickler) dumpFloat(v float64) {
 
 type opcodeProxy uint8
 
-func (proxy opcodeProxy) WriteTo(w io.Writer) (int, error) {
+func (proxy opcodeProxy) writeTo(w io.Writer) (int, error) {
 	return w.Write([]byte{byte(proxy)})
 }
 
@@ -360,6 +550,23 @@ func (p *Pickler) pushOpcode(code uint8) {
 	p.pushProxy(opcodeProxy(code))
 }
 
+type globalProxy PythonGlobal
+
+func (proxy globalProxy) writeTo(w io.Writer) (int, error) {
+	return fmt.Fprintf(w, "%c%s\n%s\n", OPCODE_GLOBAL, proxy.Module, proxy.Name)
+}
+
+/*
+Pushes a GLOBAL opcode. The module and name are always the Python 3 names
+and are converted according to PythonVersion.
+*/
+func (p *Pickler) dumpGlobal(module string, name string) {
+	if p.PythonVersion < 3 {
+		module, name = python2Name(module, name)
+	}
+	p.pushProxy(globalProxy{Module: module, Name: name})
+}
+
 type bigIntProxy struct {
 	v *big.Int
 }
@@ -367,7 +574,7 @@ type bigIntProxy struct {
 var zeroPad = []byte{0}
 var maxPad = []byte{0xff}
 
-func (proxy bigIntProxy) WriteTo(w io.Writer) (int, error) {
+func (proxy bigIntProxy) writeTo(w io.Writer) (int, error) {
 	var negative = proxy.v.Sign() == -1
 	var raw []byte
 	if negative {
@@ -459,7 +666,7 @@ func (p *Pickler) dumpUintAsLong(v uint64) {
 
 type floatProxy float64
 
-func (proxy floatProxy) WriteTo(w io.Writer) (int, error) {
+func (proxy floatProxy) writeTo(w io.Writer) (int, error) {
 	data := struct {
 		Opcode uint8
 		V      float64
@@ -473,7 +680,7 @@ func (proxy floatProxy) WriteTo(w io.Writer) (int, error) {
 
 type intProxy int32
 
-func (proxy intProxy) WriteTo(w io.Writer) (int, error) {
+func (proxy intProxy) writeTo(w io.Writer) (int, error) {
 	data := struct {
 		Opcode uint8
 		V      int32
@@ -495,7 +702,7 @@ func (proxy stringProxy) V() interface{} {
 	return proxy
 }
 
-func (proxy stringProxy) WriteTo(w io.Writer) (int, error) {
+func (proxy stringProxy) writeTo(w io.Writer) (int, error) {
 	header := struct {
 		Opcode uint8
 		Length int32
@@ -521,3 +728,69 @@ func (proxy stringProxy) WriteTo(w io.Writer) (int, error) {
 func (p *Pickler) dumpString(v string) {
 	p.pushProxy(stringProxy(v))
 }
+
+/*
+Sets are written as a call to the type with a list of the items.
+*/
+func (p *Pickler) dumpSet(name string, v reflect.Value) error {
+	p.dumpGlobal("builtins", name)
+	p.pushOpcode(OPCODE_EMPTY_LIST)
+	if v.Len() != 0 {
+		p.pushOpcode(OPCODE_MARK)
+		for _, item := range v.MapKeys() {
+			//Only items that map to true are in a map[T]bool
+			present := v.MapIndex(item)
+			if present.Kind() == reflect.Bool && !present.Bool() {
+				continue
+			}
+			err := p.dumpKey(item.Interface())
+			if err != nil {
+				return err
+			}
+		}
+		p.pushOpcode(OPCODE_APPENDS)
+	}
+	p.pushOpcode(OPCODE_TUPLE1)
+	p.pushOpcode(OPCODE_REDUCE)
+	return nil
+}
+
+/*
+Writes a key of a dict or an item of a set. Tuples that were converted
+to arrays by Unpickle are written as tuples again, and a
+PickleFrozenSetKey as a frozenset.
+*/
+func (p *Pickler) dumpKey(k interface{}) error {
+	if items, ok := tupleKeyItems(k); ok {
+		return p.dump(PickleTuple(items))
+	}
+	if set, ok := k.(PickleFrozenSetKey); ok {
+		return p.dump(set.Set())
+	}
+	return p.dump(k)
+}
+
+/*
+Bytes are written the same way Python 3 writes them for protocol 2. This
+is a call to "_codecs.encode" with the bytes as a latin-1 unicode string.
+Python 2 returns a str from the same call.
+*/
+func (p *Pickler) dumpBytes(v []byte) {
+	if len(v) == 0 {
+		p.dumpGlobal("builtins", "bytes")
+		p.pushOpcode(OPCODE_EMPTY_TUPLE)
+		p.pushOpcode(OPCODE_REDUCE)
+		return
+	}
+
+	runes := make([]rune, len(v))
+	for i, b := range v {
+		runes[i] = rune(b)
+	}
+
+	p.dumpGlobal("_codecs", "encode")
+	p.dumpString(string(runes))
+	p.dumpString("latin1")
+	p.pushOpcode(OPCODE_TUPLE2)
+	p.pushOpcode(OPCODE_REDUCE)
+}
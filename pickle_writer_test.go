@@ -5,6 +5,8 @@ import "bytes"
 import "io"
 import "reflect"
 import "math/big"
+import "database/sql"
+import "database/sql/driver"
 import "github.com/hydrogen18/stalecucumber/struct_export_test"
 
 func TestPickleBadTypes(t *testing.T) {
@@ -46,6 +48,86 @@ func TestPickleString(t *testing.T) {
 	roundTrip("test string #1", t)
 }
 
+func TestPickleBytes(t *testing.T) {
+	cases := []struct {
+		v       interface{}
+		raw     string
+		version int
+		expect  string
+	}{
+		{[]byte{}, "", 2, "\x80\x02c__builtin__\nbytes\n)R."},
+		{[]byte{}, "", 3, "\x80\x02cbuiltins\nbytes\n)R."},
+		{[]byte("a\xff"), "a\xff", 3, "\x80\x02c_codecs\nencode\nX\x03\x00\x00\x00a\xc3\xbfX\x06\x00\x00\x00latin1\x86R."},
+		{PickleBytes("a\xff"), "a\xff", 2, "\x80\x02c_codecs\nencode\nX\x03\x00\x00\x00a\xc3\xbfX\x06\x00\x00\x00latin1\x86R."},
+	}
+
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		p := NewPickler(buf)
+		p.PythonVersion = c.version
+		_, err := p.Pickle(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.expect {
+			t.Errorf("Got %q expected %q", buf.String(), c.expect)
+		}
+
+		v, err := Bytes(Unpickle(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, []byte(c.raw)) {
+			t.Errorf("Unpickled %q expected %q", v, c.raw)
+		}
+	}
+}
+
+func TestPickleSetsAndByteArrays(t *testing.T) {
+	roundTrip(PickleSet{}, t)
+	roundTrip(PickleSet{"a": true, int64(1): true}, t)
+	roundTrip(PickleFrozenSet{}, t)
+	roundTrip(PickleFrozenSet{"a": true, int64(1): true}, t)
+	roundTrip(PickleByteArray{}, t)
+	roundTrip(PickleByteArray("a\xff"), t)
+	roundTrip([]interface{}{PickleBytes("a\xff"), PickleByteArray("a\xff"), PickleSet{PickleBytes("b"): true}}, t)
+
+	//The same forms Python writes
+	cases := []struct {
+		v      interface{}
+		expect string
+	}{
+		{PickleFrozenSet{int64(1): true}, "\x80\x02c__builtin__\nfrozenset\n](J\x01\x00\x00\x00e\x85R."},
+		{PickleSet{}, "\x80\x02c__builtin__\nset\n]\x85R."},
+		{PickleByteArray{}, "\x80\x02c__builtin__\nbytearray\n)R."},
+		{PickleByteArray("a\xff"), "\x80\x02c__builtin__\nbytearray\nc_codecs\nencode\nX\x03\x00\x00\x00a\xc3\xbfX\x06\x00\x00\x00latin1\x86R\x85R."},
+	}
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		_, err := NewPickler(buf).Pickle(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.expect {
+			t.Errorf("Got %q expected %q", buf.String(), c.expect)
+		}
+	}
+}
+
+func TestPicklePythonGlobal(t *testing.T) {
+	roundTrip(PythonGlobal{Module: "collections", Name: "OrderedDict"}, t)
+
+	buf := &bytes.Buffer{}
+	_, err := NewPickler(buf).Pickle(PythonGlobal{Module: "builtins", Name: "ValueError"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = "\x80\x02cexceptions\nValueError\n."
+	if buf.String() != expect {
+		t.Fatalf("Got %q expected %q", buf.String(), expect)
+	}
+}
+
 func TestPickleInt(t *testing.T) {
 	var i int
 	i = 2300000
@@ -353,6 +435,94 @@ func TestPickleTuple(t *testing.T) {
 	inAndOut(myTuple, out, t)
 }
 
+type tuplePoint struct {
+	_     struct{} `pickle:",tuple"`
+	X     int64
+	Y     int64
+	Label string
+}
+
+func TestPickleTupleStruct(t *testing.T) {
+	p := tuplePoint{X: 1, Y: -2, Label: "origin"}
+
+	buf := &bytes.Buffer{}
+	_, err := NewPickler(buf).Pickle(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = "\x80\x02J\x01\x00\x00\x00J\xfe\xff\xff\xffX\x06\x00\x00\x00origin\x87."
+	if buf.String() != expect {
+		t.Fatalf("Got %q expected %q", buf.String(), expect)
+	}
+
+	inAndOut(p, []interface{}{int64(1), int64(-2), "origin"}, t)
+	inAndUnpack(p, t)
+	inAndUnpack([]tuplePoint{p, {X: 3}}, t)
+}
+
+func TestPickleTagOptions(t *testing.T) {
+	type inner struct {
+		Host string `pickle:"host"`
+	}
+	v := struct {
+		inner   `pickle:",inline"`
+		Name    string `pickle:"name,omitempty"`
+		Secret  string `pickle:"-"`
+		Count   int    `pickle:"count,string"`
+		Enabled *bool  `pickle:"enabled,omitempty"`
+	}{inner: inner{Host: "example.com"}, Secret: "hunter2", Count: 42}
+
+	inAndOut(v, map[interface{}]interface{}{
+		"host":  "example.com",
+		"count": "42",
+	}, t)
+
+	type Address struct {
+		Host string
+	}
+	w := struct {
+		Address `pickle:",inline"`
+		Port    int
+	}{Address{"example.com"}, 22}
+	inAndOut(w, map[interface{}]interface{}{
+		"Host": "example.com",
+		"Port": int64(22),
+	}, t)
+	inAndUnpack(w, t)
+
+	//Without inline an exported struct is nested
+	x := struct {
+		Address
+		Port int
+	}{Address{"example.com"}, 22}
+	inAndOut(x, map[interface{}]interface{}{
+		"Address": map[interface{}]interface{}{"Host": "example.com"},
+		"Port":    int64(22),
+	}, t)
+	inAndUnpack(x, t)
+}
+
+func TestPickleSetTag(t *testing.T) {
+	v := struct {
+		IDs     map[int64]struct{}      `pickle:"ids,set"`
+		Flags   map[string]bool         `pickle:"flags,set"`
+		Pairs   map[[2]interface{}]bool `pickle:"pairs,set"`
+		Lookups map[int64]struct{}      `pickle:"lookups"`
+	}{
+		IDs:     map[int64]struct{}{1: {}, 2: {}},
+		Flags:   map[string]bool{"on": true, "off": false},
+		Pairs:   map[[2]interface{}]bool{{int64(1), "a"}: true},
+		Lookups: map[int64]struct{}{},
+	}
+
+	inAndOut(v, map[interface{}]interface{}{
+		"ids":     PickleSet{int64(1): true, int64(2): true},
+		"flags":   PickleSet{"on": true},
+		"pairs":   PickleSet{[2]interface{}{int64(1), "a"}: true},
+		"lookups": map[interface{}]interface{}{},
+	}, t)
+}
+
 func inAndUnpack(v interface{}, t *testing.T) {
 	buf := &bytes.Buffer{}
 
@@ -409,3 +579,49 @@ func sanityCheck(r io.Reader, t *testing.T, expect interface{}) {
 		t.Fatalf("\n---EXPECTED:(%T)\n%v\n---GOT:(%T)\n%v", expect, expect, v, v)
 	}
 }
+
+func TestPickleNullTypes(t *testing.T) {
+	type Row struct {
+		Name  sql.NullString
+		Count sql.NullInt64
+		Ratio sql.NullFloat64
+		Ok    *sql.NullBool
+	}
+
+	v := Row{
+		Name:  sql.NullString{String: "abc", Valid: true},
+		Count: sql.NullInt64{},
+		Ratio: sql.NullFloat64{Float64: 0.5, Valid: true},
+	}
+
+	inAndOut(v, map[interface{}]interface{}{
+		"Name":  "abc",
+		"Count": PickleNone{},
+		"Ratio": 0.5,
+		"Ok":    PickleNone{},
+	}, t)
+	inAndUnpack(v, t)
+}
+
+type valuerStruct struct {
+	A int
+	B string
+}
+
+func (v valuerStruct) Value() (driver.Value, error) {
+	return v.B, nil
+}
+
+func TestPickleValuerStruct(t *testing.T) {
+	//Only the sql.Null types are pickled as their value
+	v := valuerStruct{A: 1, B: "abc"}
+	inAndOut(v, map[interface{}]interface{}{
+		"A": int64(1),
+		"B": "abc",
+	}, t)
+
+	inAndOut(&v, map[interface{}]interface{}{
+		"A": int64(1),
+		"B": "abc",
+	}, t)
+}
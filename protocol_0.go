@@ -6,7 +6,8 @@ import (
 	"math/big"
 	"strconv"
 	"unicode/utf16"
-) //import "unicode/utf8"
+	"unicode/utf8"
+)
 
 /**
 Opcode: INT
@@ -37,26 +38,43 @@ func (pm *PickleMachine) opcode_INT() error {
 		return err
 	}
 
+	v, err := parseIntLiteral(str)
+	if err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case int64:
+		pm.pushInt(v)
+	case *big.Int:
+		pm.pushLong(v)
+	default:
+		pm.push(v)
+	}
+	return nil
+}
+
+func parseIntLiteral(str string) (interface{}, error) {
 	//check for boolean sentinels
 	if len(str) == 2 {
 		switch str {
 		case "01":
-			pm.push(true)
-			return nil
+			return true, nil
 		case "00":
-			pm.push(false)
-			return nil
+			return false, nil
 		default:
 		}
 	}
 
-	n, err := strconv.ParseInt(str, 10, 64)
-	if err != nil {
-		return err
+	v, err := strconv.ParseInt(str, 10, 64)
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		//Written on a 64-bit box, or bigger than any Python int.
+		//Python returns a long for these
+		if i, ok := new(big.Int).SetString(str, 10); ok {
+			return i, nil
+		}
 	}
-
-	pm.push(n)
-	return nil
+	return v, err
 }
 
 /**
@@ -76,26 +94,35 @@ Stack before: []
 Stack after: [long]
 **/
 func (pm *PickleMachine) opcode_LONG() error {
-	i := new(big.Int)
 	str, err := pm.readString()
 	if err != nil {
 		return err
 	}
+
+	i, err := parseLongLiteral(str)
+	if err != nil {
+		return err
+	}
+	pm.pushLong(i)
+	return nil
+}
+
+func parseLongLiteral(str string) (*big.Int, error) {
+	i := new(big.Int)
 	if len(str) == 0 {
-		return fmt.Errorf("String for LONG opcode cannot be zero length")
+		return nil, fmt.Errorf("String for LONG opcode cannot be zero length")
 	}
 
 	last := str[len(str)-1]
 	if last != 'L' {
-		return fmt.Errorf("String for LONG opcode must end with %q not %q", "L", last)
+		return nil, fmt.Errorf("String for LONG opcode must end with %q not %q", "L", last)
 	}
 	v := str[:len(str)-1]
-	_, err = fmt.Sscan(v, i)
+	_, err := fmt.Sscan(v, i)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pm.push(i)
-	return nil
+	return i, nil
 }
 
 /**
@@ -118,25 +145,62 @@ func (pm *PickleMachine) opcode_STRING() error {
 		return err
 	}
 
+	//Strings are only decoded from their original bytes
+	//when an encoding is set
+	unquote := unquoteStringLiteral
+	if pm.decoding != nil {
+		unquote = unquoteStringBytes
+	}
+	v, err := unquote(str)
+	if err != nil {
+		return err
+	}
+
+	return pm.pushString(v)
+}
+
+func unquoteStringLiteral(str string) (string, error) {
+	return unquoteString(str, false)
+}
+
+/*
+Unquotes the argument of a STRING opcode without changing its bytes.
+Escapes such as "\xe9" and unescaped bytes outside of ASCII are single
+bytes, the same as Python 2 reads them. The Disassembler uses this so
+that Transcode writes the same bytes in any protocol.
+*/
+func unquoteStringBytes(str string) (string, error) {
+	return unquoteString(str, true)
+}
+
+func unquoteString(str string, raw bool) (string, error) {
+	var err error
 	//For whatever reason, the string is quoted. So the first and last character
 	//should always be the single quote, unless the string contains a single quote, then it is double quoted
 	if len(str) < 2 {
-		return fmt.Errorf("For STRING opcode, argument has invalid length %d", len(str))
+		return "", fmt.Errorf("For STRING opcode, argument has invalid length %d", len(str))
 	}
 
 	if (str[0] != '\'' || str[len(str)-1] != '\'') && (str[0] != '"' || str[len(str)-1] != '"') {
-		return fmt.Errorf("For STRING opcode, argument has poorly formed value %q", str)
+		return "", fmt.Errorf("For STRING opcode, argument has poorly formed value %q", str)
 	}
 
 	v := str[1 : len(str)-1]
 
-	f := make([]rune, 0, len(v))
+	f := make([]byte, 0, len(v))
 
 	for len(v) != 0 {
+		if raw && v[0] >= utf8.RuneSelf {
+			f = append(f, v[0])
+			v = v[1:]
+			continue
+		}
+
 		var vr rune
+		var multibyte bool
 		var replacement string
 		for _, i := range unquoteInputs {
-			vr, _, replacement, err = strconv.UnquoteChar(v, i)
+			vr, multibyte, replacement, err = strconv.UnquoteChar(v, i)
 			if err == nil {
 				break
 			}
@@ -144,15 +208,18 @@ func (pm *PickleMachine) opcode_STRING() error {
 
 		if err != nil {
 			c := v[0]
-			return fmt.Errorf("Read thus far %q. Failed to unquote character %c error:%v", string(f), c, err)
+			return "", fmt.Errorf("Read thus far %q. Failed to unquote character %c error:%v", string(f), c, err)
 		}
 		v = replacement
 
-		f = append(f, vr)
+		if raw && !multibyte {
+			f = append(f, byte(vr))
+		} else {
+			f = append(f, string(vr)...)
+		}
 	}
 
-	pm.push(string(f))
-	return nil
+	return string(f), nil
 }
 
 /**
@@ -183,6 +250,18 @@ func (pm *PickleMachine) opcode_UNICODE() error {
 		return err
 	}
 
+	v, err := decodeRawUnicodeEscape(str)
+	if err != nil {
+		return err
+	}
+
+	pm.push(v)
+
+	return nil
+}
+
+func decodeRawUnicodeEscape(str []byte) (string, error) {
+	var err error
 	f := make([]rune, 0, len(str))
 
 	var total int
@@ -216,15 +295,13 @@ func (pm *PickleMachine) opcode_UNICODE() error {
 
 		if err != nil {
 			c := str[0]
-			return fmt.Errorf("Read thus far %q. Failed to unquote character %c error:%v", string(f), c, err)
+			return "", fmt.Errorf("Read thus far %q. Failed to unquote character %c error:%v", string(f), c, err)
 		}
 
 		f = append(f, vr)
 	}
 
-	pm.push(string(f))
-
-	return nil
+	return string(f), nil
 }
 
 /**
@@ -250,15 +327,20 @@ func (pm *PickleMachine) opcode_FLOAT() error {
 	if err != nil {
 		return err
 	}
-	var v float64
-	_, err = fmt.Sscanf(str, "%f", &v)
+	v, err := parseFloatLiteral(str)
 	if err != nil {
 		return err
 	}
-	pm.push(v)
+	pm.pushFloat(v)
 	return nil
 }
 
+func parseFloatLiteral(str string) (float64, error) {
+	var v float64
+	_, err := fmt.Sscanf(str, "%f", &v)
+	return v, err
+}
+
 /**
 Opcode: APPEND
 Append an object to a list.
@@ -282,6 +364,15 @@ func (pm *PickleMachine) opcode_APPEND() error {
 		return err
 	}
 
+	if pm.builder != nil {
+		err = pm.appendWithBuilder(listI, []interface{}{v})
+		if err != nil {
+			return err
+		}
+		pm.push(listI)
+		return nil
+	}
+
 	list, ok := listI.([]interface{})
 	if !ok {
 		fmt.Errorf("Second item on top of stack must be of %T not %T", list, listI)
@@ -318,8 +409,7 @@ func (pm *PickleMachine) opcode_LIST() error {
 	//Pop the values off the stack
 	pm.popAfterIndex(markIndex)
 
-	pm.push(v)
-	return nil
+	return pm.pushList(v)
 }
 
 /**
@@ -337,7 +427,20 @@ Stack before: [mark, stackslice]
 Stack after: [tuple]
 **/
 func (pm *PickleMachine) opcode_TUPLE() error {
-	return pm.opcode_LIST()
+	markIndex, err := pm.findMark()
+	if err != nil {
+		return err
+	}
+	v := make([]interface{}, 0)
+	for i := markIndex + 1; i != len(pm.Stack); i++ {
+		v = append(v, pm.Stack[i])
+	}
+
+	//Pop the values off the stack
+	pm.popAfterIndex(markIndex)
+
+	pm.pushTuple(v)
+	return nil
 }
 
 /**
@@ -373,13 +476,23 @@ func (pm *PickleMachine) opcode_DICT() (err error) {
 		return err
 	}
 
+	if pm.builder != nil {
+		keysAndValues := append([]interface{}{}, pm.Stack[markIndex+1:]...)
+		pm.popAfterIndex(markIndex)
+		return pm.pushDictWithBuilder(keysAndValues)
+	}
+
 	v := make(map[interface{}]interface{})
 	var key interface{}
 	for i := markIndex + 1; i != len(pm.Stack); i++ {
 		if key == nil {
 			key = pm.Stack[i]
 		} else {
-			v[key] = pm.Stack[i]
+			hashable, err := hashableKey(key)
+			if err != nil {
+				return err
+			}
+			v[hashable] = pm.Stack[i]
 			key = nil
 		}
 	}
@@ -432,11 +545,24 @@ func (pm *PickleMachine) opcode_SETITEM() (err error) {
 		return err
 	}
 
+	if pm.builder != nil {
+		err = pm.setItemsWithBuilder(dictI, []interface{}{k, v})
+		if err != nil {
+			return err
+		}
+		pm.push(dictI)
+		return nil
+	}
+
 	dict, ok := dictI.(map[interface{}]interface{})
 	if !ok {
 		return fmt.Errorf("For opcode SETITEM stack item 2 from top must be of type %T not %T", dict, dictI)
 	}
 
+	k, err = hashableKey(k)
+	if err != nil {
+		return err
+	}
 	dict[k] = v
 	pm.push(dict)
 
@@ -570,11 +696,29 @@ func (pm *PickleMachine) opcode_GLOBAL() error {
 		return err
 	}
 
-	// Push a sentinel object representing the type	
-	pm.push(globalSentinel{Package: str1, Name: str2})
+	class, err := pm.findClass(str1, str2)
+	if err != nil {
+		return err
+	}
+
+	pm.push(class)
 	return nil
 }
 
+/*
+Looks up a global the same way "find_class" does in Python.
+*/
+func (pm *PickleMachine) findClass(module string, name string) (interface{}, error) {
+	if pm.fixImports {
+		module, name = python3Name(module, name)
+	}
+
+	if pm.classFinder != nil {
+		return pm.classFinder(module, name)
+	}
+	return PythonGlobal{Module: module, Name: name}, nil
+}
+
 type UnreducibleValueError struct{
 	Value interface{}
 }
@@ -620,19 +764,33 @@ func (pm *PickleMachine) opcode_REDUCE() error {
 		return err
 	}
 
-	// Sentinel should have been placed on the stack by the opcode_GLOBAL function
-	sentinel, ok := funcName.(globalSentinel)
+	// Global should have been placed on the stack by the opcode_GLOBAL function
+	global, ok := funcName.(PythonGlobal)
 	if !ok {	
 		return UnreducibleValueError{Value: funcName}
 	}
 
+	if pm.builder != nil {
+		set, ok, err := pm.reduceSetWithBuilder(global, obj)
+		if err != nil {
+			return err
+		}
+		if ok {
+			pm.push(set)
+			return nil
+		}
+	}
+
 	// Python docs say tuple on the stack, so should always be a slice here in Golang
-	args, ok := obj.([]interface{})
+	args, ok, err := pm.tupleItems(obj)
+	if err != nil {
+		return err
+	}
 	if !ok {
 		return UnreducibleValueError{Value: obj}
 	}
 
-	result, err := pm.resolver.Resolve(sentinel.Package, sentinel.Name, args)
+	result, err := pm.resolver.Resolve(global.Module, global.Name, args)
 	if err != nil {
 		return err
 	} 
@@ -681,12 +839,27 @@ func (pm *PickleMachine) opcode_BUILD() error {
 		return err
 	}
 
+	// Objects from NEWOBJ or copy_reg._reconstructor keep their state
+	if object, ok := funcName.(*PythonObject); ok {
+		object.State, err = pm.embed(obj)
+		if err != nil {
+			return err
+		}
+		pm.push(object)
+		return nil
+	}
+
 	// Sentinel should have been placed on the stack by the opcode_INST function
 	sentinel, ok := funcName.(instanceSentinel)
 	if !ok {	
 		return UnbuildableValueError{Value: funcName}
 	}
 
+	obj, err = pm.embed(obj)
+	if err != nil {
+		return err
+	}
+
 	args := append(sentinel.Args, obj)
 	if !ok {
 		return UnbuildableValueError{Value: funcName}
@@ -775,7 +948,16 @@ func (pm *PickleMachine) opcode_INST() error {
 		return err
 	} 
 
-	sentinel := instanceSentinel{Package: str1, Name: str2}
+	class, err := pm.findClass(str1, str2)
+	if err != nil {
+		return err
+	}
+	global, ok := class.(PythonGlobal)
+	if !ok {
+		return UnreducibleValueError{Value: class}
+	}
+
+	sentinel := instanceSentinel{Package: global.Module, Name: global.Name}
 
 	markIndex, err := pm.findMark()
 	
@@ -791,6 +973,10 @@ func (pm *PickleMachine) opcode_INST() error {
 	//Pop the values off the stack
 	pm.popAfterIndex(markIndex)
 
+	args, err = pm.embedAll(args)
+	if err != nil {
+		return err
+	}
 	sentinel.Args = args
 
 	// Push a sentinel object representing the type	
@@ -22,7 +22,7 @@ func (pm *PickleMachine) opcode_BININT() error {
 		return err
 	}
 
-	pm.push(int64(v))
+	pm.pushInt(int64(v))
 	return nil
 }
 
@@ -42,7 +42,7 @@ func (pm *PickleMachine) opcode_BININT1() error {
 	if err != nil {
 		return err
 	}
-	pm.push(int64(v))
+	pm.pushInt(int64(v))
 	return nil
 }
 
@@ -63,7 +63,7 @@ func (pm *PickleMachine) opcode_BININT2() error {
 	if err != nil {
 		return err
 	}
-	pm.push(int64(v))
+	pm.pushInt(int64(v))
 	return nil
 
 }
@@ -94,8 +94,7 @@ func (pm *PickleMachine) opcode_BINSTRING() error {
 	if err != nil {
 		return err
 	}
-	pm.push(str)
-	return nil
+	return pm.pushString(str)
 }
 
 /**
@@ -124,8 +123,7 @@ func (pm *PickleMachine) opcode_SHORT_BINSTRING() error {
 	if err != nil {
 		return err
 	}
-	pm.push(str)
-	return nil
+	return pm.pushString(str)
 }
 
 /**
@@ -176,7 +174,7 @@ func (pm *PickleMachine) opcode_BINFLOAT() error {
 		return err
 	}
 
-	pm.push(v)
+	pm.pushFloat(v)
 	return nil
 
 }
@@ -189,8 +187,7 @@ Stack after: [list]
 **/
 func (pm *PickleMachine) opcode_EMPTY_LIST() error {
 	v := make([]interface{}, 0)
-	pm.push(v)
-	return nil
+	return pm.pushList(v)
 }
 
 /**
@@ -216,6 +213,15 @@ func (pm *PickleMachine) opcode_APPENDS() error {
 		return err
 	}
 
+	if pm.builder != nil {
+		err = pm.appendWithBuilder(pyListI, pm.Stack[markIndex+1:])
+		if err != nil {
+			return err
+		}
+		pm.popAfterIndex(markIndex)
+		return nil
+	}
+
 	pyList, ok := pyListI.([]interface{})
 	if !ok {
 		return fmt.Errorf("APPENDS expected type %T but got (%v)%T", pyList, pyListI, pyListI)
@@ -240,7 +246,8 @@ Stack before: []
 Stack after: [tuple]
 **/
 func (pm *PickleMachine) opcode_EMPTY_TUPLE() error {
-	return pm.opcode_EMPTY_LIST()
+	pm.pushTuple(make([]interface{}, 0))
+	return nil
 }
 
 /**
@@ -250,6 +257,9 @@ Stack before: []
 Stack after: [dict]
 **/
 func (pm *PickleMachine) opcode_EMPTY_DICT() error {
+	if pm.builder != nil {
+		return pm.pushDictWithBuilder(nil)
+	}
 	pm.push(make(map[interface{}]interface{}))
 	return nil
 }
@@ -296,6 +306,15 @@ func (pm *PickleMachine) opcode_SETITEMS() (err error) {
 		return err
 	}
 
+	if pm.builder != nil {
+		err = pm.setItemsWithBuilder(vI, pm.Stack[markIndex+1:])
+		if err != nil {
+			return err
+		}
+		pm.popAfterIndex(markIndex)
+		return nil
+	}
+
 	v, ok := vI.(map[interface{}]interface{})
 	if !ok {
 		return fmt.Errorf("Opcode SETITEMS expected type %T on stack but found %v(%T)", v, vI, vI)
@@ -308,7 +327,11 @@ func (pm *PickleMachine) opcode_SETITEMS() (err error) {
 	for i := markIndex + 1; i != len(pm.Stack); i++ {
 		key := pm.Stack[i]
 		i++
-		v[key] = pm.Stack[i]
+		hashable, err := hashableKey(key)
+		if err != nil {
+			return err
+		}
+		v[hashable] = pm.Stack[i]
 	}
 
 	pm.popAfterIndex(markIndex)
@@ -24,7 +24,7 @@ func (pm *PickleMachine) opcode_LONG1() error {
 	}
 
 	if l == 0 {
-		pm.push(big.NewInt(0))
+		pm.pushLong(big.NewInt(0))
 		return nil
 	}
 
@@ -33,9 +33,20 @@ func (pm *PickleMachine) opcode_LONG1() error {
 		return err
 	}
 
+	pm.pushLong(decodeLong(reversedData))
+
+	return nil
+
+}
+
+func decodeLong(reversedData []byte) *big.Int {
+	if len(reversedData) == 0 {
+		return big.NewInt(0)
+	}
+
 	//For no obvious reason, the python pickler
 	//always reverses the bytes. Reverse it here
-	var data [256]byte
+	data := make([]byte, len(reversedData))
 	{
 		var j int
 		for i := len(reversedData) - 1; i != -1; i-- {
@@ -45,14 +56,11 @@ func (pm *PickleMachine) opcode_LONG1() error {
 	}
 
 	v := new(big.Int)
-	v.SetBytes(data[:l])
-
-	invertIfNegative(data[0], v, int(l))
+	v.SetBytes(data)
 
-	pm.push(v)
-
-	return nil
+	invertIfNegative(data[0], v, len(data))
 
+	return v
 }
 
 func invertIfNegative(first byte, v *big.Int, l int) {
@@ -89,7 +97,7 @@ func (pm *PickleMachine) opcode_LONG4() error {
 	}
 
 	if l == 0 {
-		pm.push(big.NewInt(0))
+		pm.pushLong(big.NewInt(0))
 		return nil
 	}
 
@@ -98,23 +106,7 @@ func (pm *PickleMachine) opcode_LONG4() error {
 		return err
 	}
 
-	//For no obvious reason, the python pickler
-	//always reverses the bytes. Reverse it here
-	data := make([]byte, len(reversedData))
-	{
-		var j int
-		for i := len(reversedData) - 1; i != -1; i-- {
-			data[j] = reversedData[i]
-			j++
-		}
-	}
-
-	v := new(big.Int)
-	v.SetBytes(data[:l])
-
-	invertIfNegative(data[0], v, len(data))
-
-	pm.push(v)
+	pm.pushLong(decodeLong(reversedData))
 
 	return nil
 
@@ -165,7 +157,7 @@ func (pm *PickleMachine) opcode_TUPLE1() error {
 		return err
 	}
 
-	pm.push([]interface{}{v})
+	pm.pushTuple([]interface{}{v})
 	return nil
 }
 
@@ -194,7 +186,7 @@ func (pm *PickleMachine) opcode_TUPLE2() error {
 		return err
 	}
 
-	pm.push(v)
+	pm.pushTuple(v)
 	return nil
 }
 
@@ -227,7 +219,7 @@ func (pm *PickleMachine) opcode_TUPLE3() error {
 		return err
 	}
 
-	pm.push(v)
+	pm.pushTuple(v)
 	return nil
 }
 
@@ -295,7 +287,30 @@ Stack before: [any, any]
 Stack after: [any]
 **/
 func (pm *PickleMachine) opcode_NEWOBJ() error {
-	return ErrOpcodeNotImplemented
+	argsV, err := pm.pop()
+	if err != nil {
+		return err
+	}
+	classV, err := pm.pop()
+	if err != nil {
+		return err
+	}
+
+	class, ok := classV.(PythonGlobal)
+	if !ok {
+		return UnreducibleValueError{Value: classV}
+	}
+
+	args, ok, err := pm.tupleItems(argsV)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return UnreducibleValueError{Value: argsV}
+	}
+
+	pm.push(&PythonObject{Class: class, Args: args})
+	return nil
 }
 
 /**
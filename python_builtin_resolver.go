@@ -1,7 +1,6 @@
 package stalecucumber
 
 import "fmt"
-import "strings"
 
 type UnparseablePythonGlobalError struct {
 	Args interface{}
@@ -15,25 +14,76 @@ func(this UnparseablePythonGlobalError) Error() string {
 type PythonBuiltinResolver struct {}
 
 func (this PythonBuiltinResolver) Resolve(module string, name string, args []interface{}) (interface{}, error) {
+	// Python 3 writes bytes as _codecs.encode(unicode, 'latin1') for protocol 2
+	if module == "_codecs" && name == "encode" {
+		return this.handlePythonCodecsEncode(args)
+	}
+
+	// Protocols 0 and 1 create instances of classes with copy_reg._reconstructor
+	if (module == "copy_reg" || module == "copyreg") && name == "_reconstructor" {
+		return this.handlePythonReconstructor(args)
+	}
+
 	// Up to version 2 this is always "__builtin__"
-	// In version 3+ it becomes "builtin" but that is not supported yet
-	if module != "__builtin__" {
+	// In version 3+ it becomes "builtins"
+	if module != "__builtin__" && module != "builtins" {
 		return nil, ErrUnresolvablePythonGlobal
 	}
 
 	if name == "set" {
-		return this.handlePythonSet(args)
+		set, err := this.handlePythonSet(args)
+		if err != nil {
+			return nil, err
+		}
+		return PickleSet(set), nil
+	}
+
+	if name == "frozenset" {
+		set, err := this.handlePythonSet(args)
+		if err != nil {
+			return nil, err
+		}
+		return PickleFrozenSet(set), nil
 	}
 
 	if name == "bytearray" {
 		return this.handlePythonByteArray(args)
 	}
 
+	if name == "bytes" {
+		return this.handlePythonBytes(args)
+	}
+
 
 	return nil, ErrUnresolvablePythonGlobal
 }
 
-func (this PythonBuiltinResolver) handlePythonSet(args []interface{}) (interface{}, error){
+func (this PythonBuiltinResolver) handlePythonReconstructor(args []interface{}) (interface{}, error){
+	// The arguments are (cls, base, state). The state is None unless
+	// the base is a builtin type other than object.
+	if len(args) != 3 {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected args to be of length 3",
+		}
+	}
+
+	class, ok := args[0].(PythonGlobal)
+	if !ok {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: fmt.Sprintf("Expected first argument of args to be of type %T", class),
+		}
+	}
+
+	object := &PythonObject{Class: class}
+	if _, ok := args[2].(PickleNone); !ok {
+		object.Args = []interface{}{args[2]}
+	}
+	return object, nil
+}
+
+func (this PythonBuiltinResolver) handlePythonSet(args []interface{}) (map[interface{}]bool, error){
 	
 	if len(args) != 1 {
 		return nil, UnparseablePythonGlobalError{
@@ -53,7 +103,11 @@ func (this PythonBuiltinResolver) handlePythonSet(args []interface{}) (interface
 	// A map is the equivalent golang type for a python set
 	set := make(map[interface{}]bool, len(tuple))
 	for _, item := range tuple {
-		set[item] = true
+		hashable, err := hashableKey(item)
+		if err != nil {
+			return nil, err
+		}
+		set[hashable] = true
 	}
 
 	return set, nil
@@ -62,8 +116,21 @@ func (this PythonBuiltinResolver) handlePythonSet(args []interface{}) (interface
 func (this PythonBuiltinResolver) handlePythonByteArray(args []interface{}) (interface{}, error){	
 	// Up to version 2 the implementation of bytearray always pickles as a tuple like
 	// (theStringValue, 'latin-1', )
-	// version 3+ of pickle is different but we're not supporting that presently
-	if len(args) != 2{
+	// version 3+ pickles it as a tuple holding bytes, or an empty tuple
+	switch len(args) {
+	case 0:
+		return PickleByteArray{}, nil
+	case 1:
+		value, ok := args[0].(PickleBytes)
+		if !ok {
+			return nil, UnparseablePythonGlobalError{
+				Args: args,
+				Message: "Expected first arg to be bytes",
+			}
+		}
+		return PickleByteArray(value), nil
+	case 2:
+	default:
 		return nil, UnparseablePythonGlobalError{
 			Args: args,
 			Message: "Expected args to be of length 2",
@@ -71,8 +138,14 @@ func (this PythonBuiltinResolver) handlePythonByteArray(args []interface{}) (int
 	}
 
 	const magic = `latin-1`
-	magicValue, ok := args[1].(string)
-	if !ok || magicValue != magic{
+	var magicValue string
+	switch v := args[1].(type) {
+	case string:
+		magicValue = v
+	case PickleBytes:
+		magicValue = string(v)
+	}
+	if magicValue != magic{
 		return nil, UnparseablePythonGlobalError{
 			Args: args,
 			Message: fmt.Sprintf("Expected second arg to be string %q", magic),
@@ -86,6 +159,75 @@ func (this PythonBuiltinResolver) handlePythonByteArray(args []interface{}) (int
 			Message: "Expected first arg to be a string",
 		}
 	}
-	return strings.NewReader(value), nil
+
+	raw, ok := encodeLatin1(value)
+	if !ok {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected first arg to contain only latin-1 characters",
+		}
+	}
+	return PickleByteArray(raw), nil
+}
+
+func (this PythonBuiltinResolver) handlePythonBytes(args []interface{}) (interface{}, error){
+	// Empty bytes are pickled as a call with no arguments
+	if len(args) != 0 {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected args to be of length 0",
+		}
+	}
+
+	return PickleBytes(""), nil
+}
+
+func (this PythonBuiltinResolver) handlePythonCodecsEncode(args []interface{}) (interface{}, error){
+	if len(args) != 2 {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected args to be of length 2",
+		}
+	}
+
+	encoding, ok := args[1].(string)
+	if !ok || (encoding != "latin1" && encoding != "latin-1") {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected second arg to be string \"latin1\"",
+		}
+	}
+
+	value, ok := args[0].(string)
+	if !ok {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected first arg to be a string",
+		}
+	}
+
+	raw, ok := encodeLatin1(value)
+	if !ok {
+		return nil, UnparseablePythonGlobalError{
+			Args: args,
+			Message: "Expected first arg to contain only latin-1 characters",
+		}
+	}
+	return PickleBytes(raw), nil
+}
+
+/*
+Converts each character of a string into a single byte. Returns false
+if any character is outside of latin-1.
+*/
+func encodeLatin1(s string) (string, bool) {
+	raw := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return "", false
+		}
+		raw = append(raw, byte(r))
+	}
+	return string(raw), true
 }
  
\ No newline at end of file
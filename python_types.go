@@ -1,5 +1,9 @@
 package stalecucumber
 
+import "fmt"
+import "reflect"
+import "sort"
+
 /*
 This type is used internally to represent a concept known as a mark
 on the Pickle Machine's stack. Oddly formed pickled data could return
@@ -12,6 +16,63 @@ func (_ PickleMark) String() string {
 	return "PickleMachine Mark"
 }
 
+/*
+This type holds the bytes of a Python 2 string when an Unpickler has
+Encoding set to "bytes". It is a string so that it can be used as
+a map key.
+*/
+type PickleBytes string
+
+/*
+This type holds a Python bytearray.
+*/
+type PickleByteArray []byte
+
+/*
+This type holds a Python set. Each item of the set is a key that maps
+to true.
+*/
+type PickleSet map[interface{}]bool
+
+/*
+This type holds a Python frozenset. Each item of the set is a key that
+maps to true. Unlike Python, it can not be used as a key of a dict or an
+item of a set.
+*/
+type PickleFrozenSet map[interface{}]bool
+
+/*
+This type is a reference to a Python global, such as a class or a
+function. It is the result of unpickling a global that is not called,
+for example a class stored as a value in a dict. The Pickler writes it
+as a GLOBAL opcode.
+*/
+type PythonGlobal struct {
+	Module string
+	Name   string
+}
+
+func (pg PythonGlobal) String() string {
+	return pg.Module + "." + pg.Name
+}
+
+/*
+This type holds an instance of a Python class, such as one pickled by
+NEWOBJ or by copy_reg._reconstructor. Args are the arguments passed to
+the class when it was created, which are often empty. State is the
+value given to __setstate__, usually a dict of the attributes of the
+instance, or nil if the pickle has none. Unpickle returns it as a
+pointer so that every reference to the same instance shares its State.
+
+UnpackInto can unpack it into a Go type registered for the class in a
+ClassRegistry.
+*/
+type PythonObject struct {
+	Class PythonGlobal
+	Args  []interface{}
+	State interface{}
+}
+
 /*
 This type is used to represent the Python object "None"
 */
@@ -20,3 +81,106 @@ type PickleNone struct{}
 func (_ PickleNone) String() string {
 	return "Python None"
 }
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+/*
+This error is returned when a value that can not be hashed, such as a
+list or a dict, is used as the key of a dict or the item of a set.
+*/
+type UnhashableKeyError struct {
+	Key interface{}
+}
+
+func (uke UnhashableKeyError) Error() string {
+	return fmt.Sprintf("Cannot use unhashable type %T as a dict key or set item", uke.Key)
+}
+
+/*
+This type holds a Python frozenset that is the key of a dict or the item
+of a set, since a PickleFrozenSet can not be a map key. Two keys are
+equal if their frozensets have the same items.
+*/
+type PickleFrozenSetKey struct {
+	//The items sorted as an array such as [2]interface{}
+	items interface{}
+}
+
+/*
+Returns the frozenset held by the key.
+*/
+func (k PickleFrozenSetKey) Set() PickleFrozenSet {
+	set := make(PickleFrozenSet)
+	v := reflect.ValueOf(k.items)
+	for i := 0; v.IsValid() && i != v.Len(); i++ {
+		set[v.Index(i).Interface()] = true
+	}
+	return set
+}
+
+/*
+Python tuples can be the keys of a dict or the items of a set but a Go
+slice can not be a map key. A tuple used this way is converted to an
+array of interface{} with the same length, such as [2]interface{}.
+Tuples nested inside it are converted too. A frozenset is converted to
+a PickleFrozenSetKey. Any other value that can not be a map key returns
+an UnhashableKeyError.
+*/
+func hashableKey(k interface{}) (interface{}, error) {
+	switch k := k.(type) {
+	case []interface{}:
+		return hashableArray(k)
+	case PickleFrozenSet:
+		//The items of a set are already hashable
+		items := make([]interface{}, 0, len(k))
+		for item := range k {
+			items = append(items, item)
+		}
+		//Equal sets must have their items in the same order
+		sort.Slice(items, func(i, j int) bool {
+			return sortKey(items[i]) < sortKey(items[j])
+		})
+		arr, err := hashableArray(items)
+		if err != nil {
+			return nil, err
+		}
+		return PickleFrozenSetKey{items: arr}, nil
+	}
+
+	if t := reflect.TypeOf(k); t != nil && !t.Comparable() {
+		return nil, UnhashableKeyError{Key: k}
+	}
+	return k, nil
+}
+
+func sortKey(v interface{}) string {
+	return fmt.Sprintf("%T:%#v", v, v)
+}
+
+func hashableArray(items []interface{}) (interface{}, error) {
+	arr := reflect.New(reflect.ArrayOf(len(items), interfaceType)).Elem()
+	for i, item := range items {
+		item, err := hashableKey(item)
+		if err != nil {
+			return nil, err
+		}
+		arr.Index(i).Set(reflect.ValueOf(&item).Elem())
+	}
+	return arr.Interface(), nil
+}
+
+/*
+Returns the items of a tuple that was converted by hashableKey.
+*/
+func tupleKeyItems(k interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(k)
+	if v.Kind() != reflect.Array || v.Type().Elem() != interfaceType {
+		return nil, false
+	}
+
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, true
+}
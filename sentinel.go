@@ -1,10 +1,5 @@
 package stalecucumber
 
-type globalSentinel struct{
-	Package string 
-	Name string
-}
-
 type instanceSentinel struct{
 	Package string 
 	Name string
@@ -0,0 +1,215 @@
+package stalecucumber
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+This type is returned as the cause of a PickleMachineError when a Python 2
+string cannot be decoded with the encoding of an Unpickler and the error
+policy is "strict". Position is the index of the offending byte within the
+string.
+*/
+type StringDecodingError struct {
+	Encoding string
+	Position int
+	Byte     byte
+}
+
+func (sde StringDecodingError) Error() string {
+	return fmt.Sprintf("'%s' codec can't decode byte 0x%02x in position %d", sde.Encoding, sde.Byte, sde.Position)
+}
+
+const (
+	encodingASCII  = "ascii"
+	encodingLatin1 = "latin-1"
+	encodingUTF8   = "utf-8"
+	encodingCP1252 = "cp1252"
+	encodingBytes  = "bytes"
+)
+
+var encodingAliases = map[string]string{
+	"ascii":        encodingASCII,
+	"us-ascii":     encodingASCII,
+	"latin-1":      encodingLatin1,
+	"latin1":       encodingLatin1,
+	"iso-8859-1":   encodingLatin1,
+	"iso8859-1":    encodingLatin1,
+	"l1":           encodingLatin1,
+	"utf-8":        encodingUTF8,
+	"utf8":         encodingUTF8,
+	"cp1252":       encodingCP1252,
+	"windows-1252": encodingCP1252,
+	"bytes":        encodingBytes,
+}
+
+/*
+The characters of cp1252 from 0x80 to 0x9f. Zero marks a byte with no
+character assigned.
+*/
+var cp1252Table = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+type stringDecoder struct {
+	encoding string
+	errors   string
+}
+
+func newStringDecoder(encoding string, errors string) (*stringDecoder, error) {
+	canonical, ok := encodingAliases[strings.Replace(strings.ToLower(encoding), "_", "-", -1)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown encoding %q", encoding)
+	}
+
+	switch errors {
+	case "":
+		errors = "strict"
+	case "strict", "replace", "ignore":
+	default:
+		return nil, fmt.Errorf("Unknown error policy %q", errors)
+	}
+
+	return &stringDecoder{encoding: canonical, errors: errors}, nil
+}
+
+/*
+Converts the bytes of a Python 2 string into the value pushed onto the
+stack.
+*/
+func (sd *stringDecoder) decode(raw string) (interface{}, error) {
+	switch sd.encoding {
+	case encodingBytes:
+		return PickleBytes(raw), nil
+	case encodingUTF8:
+		if utf8.ValidString(raw) {
+			return raw, nil
+		}
+		return sd.decodeUTF8(raw)
+	}
+
+	ascii := true
+	for i := 0; i != len(raw) && ascii; i++ {
+		ascii = raw[i] < utf8.RuneSelf
+	}
+	if ascii {
+		return raw, nil
+	}
+
+	buf := make([]rune, 0, len(raw))
+	for i := 0; i != len(raw); i++ {
+		c := raw[i]
+		r := rune(c)
+		switch {
+		case c < utf8.RuneSelf || sd.encoding == encodingLatin1:
+		case sd.encoding == encodingCP1252 && c < 0xa0:
+			r = cp1252Table[c-0x80]
+		case sd.encoding == encodingCP1252:
+		default:
+			r = 0
+		}
+
+		if r == 0 && c != 0 {
+			var err error
+			buf, err = sd.invalid(buf, raw, i)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		buf = append(buf, r)
+	}
+
+	return string(buf), nil
+}
+
+func (sd *stringDecoder) decodeUTF8(raw string) (interface{}, error) {
+	buf := make([]rune, 0, len(raw))
+	for i := 0; i < len(raw); {
+		r, size := utf8.DecodeRuneInString(raw[i:])
+		if r == utf8.RuneError && size <= 1 {
+			var err error
+			buf, err = sd.invalid(buf, raw, i)
+			if err != nil {
+				return nil, err
+			}
+			i += invalidUTF8Length(raw[i:])
+			continue
+		}
+		buf = append(buf, r)
+		i += size
+	}
+
+	return string(buf), nil
+}
+
+func (sd *stringDecoder) invalid(buf []rune, raw string, position int) ([]rune, error) {
+	switch sd.errors {
+	case "replace":
+		return append(buf, utf8.RuneError), nil
+	case "ignore":
+		return buf, nil
+	}
+
+	return buf, StringDecodingError{
+		Encoding: sd.encoding,
+		Position: position,
+		Byte:     raw[position],
+	}
+}
+
+/*
+Returns the number of bytes Python replaces with a single replacement
+character at the start of an invalid UTF-8 sequence. This is the longest
+prefix of a valid sequence that is present, or one byte.
+*/
+func invalidUTF8Length(s string) int {
+	var n int
+	lo, hi := byte(0x80), byte(0xbf)
+	switch c := s[0]; {
+	case c >= 0xc2 && c <= 0xdf:
+		n = 2
+	case c == 0xe0:
+		n, lo = 3, 0xa0
+	case c == 0xed:
+		n, hi = 3, 0x9f
+	case c >= 0xe1 && c <= 0xef:
+		n = 3
+	case c == 0xf0:
+		n, lo = 4, 0x90
+	case c == 0xf4:
+		n, hi = 4, 0x8f
+	case c >= 0xf1 && c <= 0xf3:
+		n = 4
+	default:
+		return 1
+	}
+
+	i := 1
+	for ; i < n && i < len(s); i++ {
+		if s[i] < lo || s[i] > hi {
+			break
+		}
+		lo, hi = 0x80, 0xbf
+	}
+	return i
+}
+
+func (pm *PickleMachine) pushString(v string) error {
+	if pm.decoding == nil {
+		pm.push(v)
+		return nil
+	}
+
+	decoded, err := pm.decoding.decode(v)
+	if err != nil {
+		return err
+	}
+	pm.push(decoded)
+	return nil
+}
@@ -0,0 +1,104 @@
+package stalecucumber
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+A tuple of Python 2 strings written with STRING, SHORT_BINSTRING and
+BINSTRING. The results were produced by "pickle.loads" in Python 3.
+*/
+const stringDecodingInput = "(S'caf\\xe9'\nU\x04\x80\x81a\x9fU\x03\xe2\x82xT\x02\x00\x00\x00okt."
+
+func TestUnpicklerEncoding(t *testing.T) {
+	cases := []struct {
+		encoding string
+		errors   string
+		expect   []interface{}
+	}{
+		{"ascii", "replace", []interface{}{"caf�", "��a�", "��x", "ok"}},
+		{"ascii", "ignore", []interface{}{"caf", "a", "x", "ok"}},
+		{"latin-1", "", []interface{}{"café", "\u0080\u0081a\u009f", "â\u0082x", "ok"}},
+		{"Latin_1", "strict", []interface{}{"café", "\u0080\u0081a\u009f", "â\u0082x", "ok"}},
+		{"utf-8", "replace", []interface{}{"caf�", "��a�", "�x", "ok"}},
+		{"utf8", "ignore", []interface{}{"caf", "a", "x", "ok"}},
+		{"cp1252", "replace", []interface{}{"café", "€�aŸ", "â‚x", "ok"}},
+		{"cp1252", "ignore", []interface{}{"café", "€aŸ", "â‚x", "ok"}},
+		{"bytes", "", []interface{}{PickleBytes("caf\xe9"), PickleBytes("\x80\x81a\x9f"), PickleBytes("\xe2\x82x"), PickleBytes("ok")}},
+	}
+
+	for _, c := range cases {
+		u := NewUnpickler(strings.NewReader(stringDecodingInput))
+		u.Encoding = c.encoding
+		u.Errors = c.errors
+		v, err := u.Unpickle()
+		if err != nil {
+			t.Fatalf("%s/%s: %v", c.encoding, c.errors, err)
+		}
+		if !reflect.DeepEqual(v, c.expect) {
+			t.Errorf("%s/%s: got %q expected %q", c.encoding, c.errors, v, c.expect)
+		}
+	}
+}
+
+func TestUnpicklerEncodingStrict(t *testing.T) {
+	cases := []struct {
+		encoding string
+		expect   StringDecodingError
+	}{
+		{"ascii", StringDecodingError{Encoding: "ascii", Position: 3, Byte: 0xe9}},
+		{"utf-8", StringDecodingError{Encoding: "utf-8", Position: 3, Byte: 0xe9}},
+		{"cp1252", StringDecodingError{Encoding: "cp1252", Position: 1, Byte: 0x81}},
+	}
+
+	for _, c := range cases {
+		u := NewUnpickler(strings.NewReader(stringDecodingInput))
+		u.Encoding = c.encoding
+		_, err := u.Unpickle()
+		pme, ok := err.(PickleMachineError)
+		if !ok {
+			t.Fatalf("%s: expected %T but got (%T)%v", c.encoding, pme, err, err)
+		}
+		if pme.Err != c.expect {
+			t.Errorf("%s: got %v expected %v", c.encoding, pme.Err, c.expect)
+		}
+	}
+
+	u := NewUnpickler(strings.NewReader(stringDecodingInput))
+	u.Encoding = "koi8-r"
+	_, err := u.Unpickle()
+	if err == nil {
+		t.Fatal("Expected error for unknown encoding")
+	}
+}
+
+func TestUnpicklerEncodingBytes(t *testing.T) {
+	//A dictionary with a Python 2 string key and a bytearray
+	const input = "\x80\x02}q\x00U\x04dataq\x01c__builtin__\nbytearray\nq\x02X\x03\x00\x00\x00\xc3\xbfaU\x07latin-1\x86Rq\x03s."
+
+	u := NewUnpickler(strings.NewReader(input))
+	u.Encoding = "bytes"
+	v, err := u.Unpickle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dst struct {
+		Data io.Reader
+	}
+	err = UnpackInto(&dst).From(v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Data == nil {
+		t.Fatal("Expected bytearray to be unpacked")
+	}
+
+	b, err := Bytes(PickleBytes("\xff"), nil)
+	if err != nil || string(b) != "\xff" {
+		t.Fatalf("Got %q %v", b, err)
+	}
+}
@@ -0,0 +1,252 @@
+package stalecucumber
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+The options that follow the name in a "pickle" struct tag, such as
+"tuple" in `pickle:"name,tuple"`. Options are separated by commas, the
+same as the tags of "encoding/json".
+*/
+type tagOptions string
+
+/*
+Splits a "pickle" struct tag into the name and the options.
+*/
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, tagOptions("")
+}
+
+func (o tagOptions) Contains(option string) bool {
+	s := string(o)
+	for len(s) != 0 {
+		var next string
+		if i := strings.Index(s, ","); i != -1 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == option {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+/*
+Reports if a struct type is pickled as a Python tuple. A struct is
+marked as a tuple with a blank field that has the "tuple" option.
+
+	type Point struct {
+		_ struct{} `pickle:",tuple"`
+		X float64
+		Y float64
+	}
+*/
+func isTupleStruct(t reflect.Type) bool {
+	for i := 0; i != t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Name != "_" {
+			continue
+		}
+		_, options := parseTag(field.Tag.Get(PICKLE_TAG))
+		if options.Contains("tuple") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Returns the exported fields of a struct in the order they are declared.
+These are the items of a struct that is a tuple.
+*/
+func tupleFields(v reflect.Value) []reflect.Value {
+	t := v.Type()
+	fields := make([]reflect.Value, 0, t.NumField())
+	for i := 0; i != t.NumField(); i++ {
+		if len(t.Field(i).PkgPath) == 0 {
+			fields = append(fields, v.Field(i))
+		}
+	}
+	return fields
+}
+
+/*
+Returns the value of an option written as "option=value", such as
+"default=42". The value can not contain a comma.
+*/
+func (o tagOptions) Value(option string) (string, bool) {
+	prefix := option + "="
+	for _, s := range strings.Split(string(o), ",") {
+		if strings.HasPrefix(s, prefix) {
+			return s[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+/*
+A field of a struct that is pickled as a key of a Python dict.
+*/
+type structField struct {
+	name         string
+	goName       string
+	index        []int
+	omitEmpty    bool
+	required     bool
+	asString     bool
+	asSet        bool
+	defaultValue string
+	hasDefault   bool
+	remain       bool
+}
+
+/*
+Returns the fields of a struct that are pickled as keys of a Python
+dict. Fields without a name in their tag are named by the mapper.
+Fields tagged "-" are left out. The fields of embedded structs
+that are not exported, and of exported ones tagged "inline", are
+included as if they belonged to the outer struct. When two fields
+have the same name the one that is least deeply embedded is used.
+
+The index of the first field with the "remain" option is returned
+separately, or nil if there is none. It holds the keys of a dict that
+do not belong to any other field.
+
+The result is cached for each type and mapper, the same as
+"encoding/json" does, and must not be modified.
+*/
+func structFields(t reflect.Type, mapper NameMapper) ([]structField, []int) {
+	if mapper == nil {
+		mapper = DefaultNameMapper{}
+	}
+
+	//A mapper that can not be a map key is not cached
+	if !reflect.TypeOf(mapper).Comparable() {
+		return buildStructFields(t, mapper)
+	}
+
+	key := structFieldsKey{t: t, mapper: mapper}
+	if cached, ok := structFieldsCache.Load(key); ok {
+		entry := cached.(structFieldsEntry)
+		return entry.fields, entry.remain
+	}
+	fields, remain := buildStructFields(t, mapper)
+	structFieldsCache.Store(key, structFieldsEntry{fields: fields, remain: remain})
+	return fields, remain
+}
+
+type structFieldsKey struct {
+	t      reflect.Type
+	mapper NameMapper
+}
+
+type structFieldsEntry struct {
+	fields []structField
+	remain []int
+}
+
+var structFieldsCache sync.Map
+
+func buildStructFields(t reflect.Type, mapper NameMapper) ([]structField, []int) {
+	fields := appendStructFields(nil, t, nil, mapper)
+
+	var remain []int
+	named := fields[:0]
+	for _, field := range fields {
+		if !field.remain {
+			named = append(named, field)
+		} else if remain == nil {
+			remain = field.index
+		}
+	}
+	fields = named
+
+	depth := make(map[string]int, len(fields))
+	for _, field := range fields {
+		if d, ok := depth[field.name]; !ok || len(field.index) < d {
+			depth[field.name] = len(field.index)
+		}
+	}
+
+	result := fields[:0]
+	for _, field := range fields {
+		if depth[field.name] == len(field.index) {
+			result = append(result, field)
+			//Only the first field at the shallowest depth is used
+			depth[field.name] = -1
+		}
+	}
+	return result, remain
+}
+
+func appendStructFields(fields []structField, t reflect.Type, parent []int, mapper NameMapper) []structField {
+	for i := 0; i != t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(PICKLE_TAG)
+		if tag == "-" {
+			continue
+		}
+		name, options := parseTag(tag)
+
+		index := make([]int, len(parent)+1)
+		copy(index, parent)
+		index[len(parent)] = i
+
+		exported := len(field.PkgPath) == 0
+		if field.Anonymous && field.Type.Kind() == reflect.Struct &&
+			(!exported || options.Contains("inline")) {
+			fields = appendStructFields(fields, field.Type, index, mapper)
+			continue
+		}
+		if !exported {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = mapper.FieldKey(field.Name)
+		}
+		defaultValue, hasDefault := options.Value("default")
+		fields = append(fields, structField{
+			name:         name,
+			goName:       field.Name,
+			index:        index,
+			omitEmpty:    options.Contains("omitempty"),
+			required:     options.Contains("required"),
+			asString:     options.Contains("string"),
+			asSet:        options.Contains("set"),
+			defaultValue: defaultValue,
+			hasDefault:   hasDefault,
+			remain:       options.Contains("remain") && field.Type.Kind() == reflect.Map,
+		})
+	}
+	return fields
+}
+
+/*
+Reports if a value is left out of a pickle by the "omitempty" option.
+This is the same as "encoding/json".
+*/
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package stalecucumber
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	name, options := parseTag("meow,tuple,omitempty")
+	if name != "meow" || !options.Contains("tuple") || !options.Contains("omitempty") || options.Contains("meow") {
+		t.Fatalf("Got %q %q", name, options)
+	}
+
+	name, options = parseTag("meow")
+	if name != "meow" || options.Contains("") {
+		t.Fatalf("Got %q %q", name, options)
+	}
+
+	name, options = parseTag(",tuple")
+	if name != "" || !options.Contains("tuple") {
+		t.Fatalf("Got %q %q", name, options)
+	}
+}
@@ -0,0 +1,510 @@
+package stalecucumber
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+This type is returned by Transcode when the input contains an opcode that
+has no equivalent in the requested protocol.
+*/
+type TranscodingError struct {
+	Offset   int64
+	Opcode   uint8
+	Name     string
+	Protocol int
+}
+
+func (te TranscodingError) Error() string {
+	return fmt.Sprintf("Opcode %s(0x%x) at offset %d has no equivalent in protocol %d", te.Name, te.Opcode, te.Offset, te.Protocol)
+}
+
+/*
+Rewrites the pickle read from r into w using the opcodes of another
+pickle protocol. The protocol must be 0, 1 or 2.
+
+The pickle is converted opcode by opcode without creating any Go values,
+so everything the pickle describes is preserved. This includes tuples,
+globals, REDUCE and BUILD sequences, instances and objects shared through
+the memo. For example, a protocol 0 archive written by Python 2 can be
+rewritten as protocol 2 so that it is smaller and faster to load.
+
+Some opcodes can not be expressed in older protocols. For example NEWOBJ
+only exists in protocol 2. If the input contains any of these a
+TranscodingError is returned. Failures to decode the input are returned as
+an instance of DisassemblyError.
+
+The complete pickle is held in memory while it is rewritten.
+*/
+func Transcode(w io.Writer, r io.Reader, protocol int) error {
+	if protocol < 0 || protocol > 2 {
+		return fmt.Errorf("Unsupported protocol version #%d requested", protocol)
+	}
+
+	t, err := newTranscoder(r, protocol)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	err = t.writeTo(bw)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type transcoderEntry struct {
+	//The index of the first instruction that makes up this stack item
+	start int
+}
+
+type transcoderMark struct {
+	position int
+	index    int
+}
+
+type transcoder struct {
+	protocol int
+	program  []Instruction
+
+	//MARK opcodes that are not written, indexed by instruction
+	dropMark map[int]bool
+	//The number of stack items above the mark for each mark consumer
+	markItems map[int]int
+	//The MARK consumed by each mark consumer
+	markOf map[int]int
+	//The number of MARK opcodes to insert before an instruction
+	insertMarks map[int]int
+	//APPEND or SETITEM opcodes to insert before an instruction
+	insertOp map[int]uint8
+}
+
+func newTranscoder(r io.Reader, protocol int) (*transcoder, error) {
+	t := &transcoder{
+		protocol:    protocol,
+		dropMark:    make(map[int]bool),
+		markItems:   make(map[int]int),
+		markOf:      make(map[int]int),
+		insertMarks: make(map[int]int),
+		insertOp:    make(map[int]uint8),
+	}
+
+	d := newCapturingDisassembler(r)
+	for {
+		ins, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.program = append(t.program, ins)
+	}
+
+	return t, t.plan()
+}
+
+/*
+Replays the stack effect of every opcode to find out which opcodes build
+each stack item. This is needed wherever the target protocol needs a MARK
+that the input lacks, or lacks a MARK that the input has.
+*/
+func (t *transcoder) plan() error {
+	var stack []transcoderEntry
+	var marks []transcoderMark
+
+	for i, ins := range t.program {
+		info := opcodeInfos[ins.Opcode]
+
+		if info.PushMark {
+			marks = append(marks, transcoderMark{position: len(stack), index: i})
+			stack = append(stack, transcoderEntry{start: i})
+			continue
+		}
+
+		start := i
+		if info.Mark {
+			mark := marks[len(marks)-1]
+			marks = marks[:len(marks)-1]
+			items := stack[mark.position+1:]
+			t.markOf[i] = mark.index
+			t.markItems[i] = len(items)
+
+			err := t.planMarkConsumer(ins, mark.index, items)
+			if err != nil {
+				return err
+			}
+
+			start = mark.index
+			stack = stack[:mark.position]
+		}
+
+		for j := 0; j != info.Before; j++ {
+			start = stack[len(stack)-1].start
+			stack = stack[:len(stack)-1]
+		}
+
+		switch ins.Opcode {
+		case OPCODE_TUPLE1, OPCODE_TUPLE2, OPCODE_TUPLE3:
+			if t.protocol < 2 {
+				t.insertMarks[start]++
+			}
+		}
+
+		for j := 0; j != info.After; j++ {
+			stack = append(stack, transcoderEntry{start: start})
+		}
+	}
+
+	return nil
+}
+
+func (t *transcoder) planMarkConsumer(ins Instruction, markIndex int, items []transcoderEntry) error {
+	switch ins.Opcode {
+	case OPCODE_APPENDS, OPCODE_SETITEMS:
+		if t.protocol != 0 {
+			return nil
+		}
+
+		//Protocol 0 has no batched opcodes. Each item is added as soon as
+		//the next one starts, and the last one in place of the batch
+		group := 1
+		op := uint8(OPCODE_APPEND)
+		if ins.Opcode == OPCODE_SETITEMS {
+			group = 2
+			op = OPCODE_SETITEM
+		}
+		if len(items)%group != 0 {
+			return DisassemblyError{Offset: ins.Offset, Opcode: ins.Opcode, Name: ins.Name,
+				Err: fmt.Errorf("Found odd number of items on stack after mark:%d", len(items))}
+		}
+		for j := group; j < len(items); j += group {
+			t.insertOp[items[j].start] = op
+		}
+		t.dropMark[markIndex] = true
+
+	case OPCODE_LIST, OPCODE_DICT:
+		if t.protocol != 0 && len(items) == 0 {
+			t.dropMark[markIndex] = true
+		}
+
+	case OPCODE_TUPLE:
+		if t.protocol != 0 && len(items) == 0 {
+			t.dropMark[markIndex] = true
+		}
+		if t.protocol == 2 && len(items) <= 3 {
+			t.dropMark[markIndex] = true
+		}
+	}
+	return nil
+}
+
+func (t *transcoder) writeTo(w io.Writer) error {
+	if t.protocol == 2 {
+		_, err := w.Write([]byte{OPCODE_PROTO, 2})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, ins := range t.program {
+		if op, ok := t.insertOp[i]; ok {
+			_, err := w.Write([]byte{op})
+			if err != nil {
+				return err
+			}
+		}
+
+		for j := 0; j != t.insertMarks[i]; j++ {
+			_, err := w.Write([]byte{OPCODE_MARK})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := t.writeInstruction(w, i, ins)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *transcoder) notTranscodable(ins Instruction) error {
+	return TranscodingError{
+		Offset:   ins.Offset,
+		Opcode:   ins.Opcode,
+		Name:     ins.Name,
+		Protocol: t.protocol,
+	}
+}
+
+func (t *transcoder) writeInstruction(w io.Writer, i int, ins Instruction) error {
+	var err error
+	switch ins.Opcode {
+	case OPCODE_PROTO:
+		//Written once at the start of the output
+		return nil
+
+	case OPCODE_MARK:
+		if t.dropMark[i] {
+			return nil
+		}
+
+	case OPCODE_INT, OPCODE_BININT, OPCODE_BININT1, OPCODE_BININT2:
+		switch v := ins.Arg.(type) {
+		case bool:
+			return t.writeBool(w, v)
+		case int64:
+			return t.writeInt(w, v)
+		}
+
+	case OPCODE_NEWTRUE, OPCODE_NEWFALSE:
+		return t.writeBool(w, ins.Opcode == OPCODE_NEWTRUE)
+
+	case OPCODE_LONG, OPCODE_LONG1, OPCODE_LONG4:
+		v := ins.Arg.(*big.Int)
+		if t.protocol < 2 {
+			_, err = fmt.Fprintf(w, "%c%sL\n", OPCODE_LONG, v.String())
+			return err
+		}
+		_, err = bigIntProxy{v}.writeTo(w)
+		return err
+
+	case OPCODE_STRING, OPCODE_BINSTRING, OPCODE_SHORT_BINSTRING:
+		return t.writeString(w, ins.Arg.(string))
+
+	case OPCODE_UNICODE, OPCODE_BINUNICODE:
+		v := ins.Arg.(string)
+		if t.protocol == 0 {
+			_, err = fmt.Fprintf(w, "%c%s\n", OPCODE_UNICODE, encodeRawUnicodeEscape(v))
+			return err
+		}
+		_, err = stringProxy(v).writeTo(w)
+		return err
+
+	case OPCODE_FLOAT, OPCODE_BINFLOAT:
+		v := ins.Arg.(float64)
+		if t.protocol == 0 {
+			_, err = fmt.Fprintf(w, "%c%s\n", OPCODE_FLOAT, formatFloatLiteral(v))
+			return err
+		}
+		_, err = floatProxy(v).writeTo(w)
+		return err
+
+	case OPCODE_GET, OPCODE_BINGET, OPCODE_LONG_BINGET,
+		OPCODE_PUT, OPCODE_BINPUT, OPCODE_LONG_BINPUT:
+		index, err := memoIndexArgument(ins)
+		if err != nil {
+			return err
+		}
+		get := ins.Opcode == OPCODE_GET || ins.Opcode == OPCODE_BINGET || ins.Opcode == OPCODE_LONG_BINGET
+		return writeMemoOpcode(w, get, t.protocol == 0, index)
+
+	case OPCODE_EMPTY_LIST, OPCODE_EMPTY_TUPLE, OPCODE_EMPTY_DICT:
+		if t.protocol == 0 {
+			code := map[uint8]uint8{
+				OPCODE_EMPTY_LIST:  OPCODE_LIST,
+				OPCODE_EMPTY_TUPLE: OPCODE_TUPLE,
+				OPCODE_EMPTY_DICT:  OPCODE_DICT,
+			}[ins.Opcode]
+			_, err = w.Write([]byte{OPCODE_MARK, code})
+			return err
+		}
+
+	case OPCODE_LIST, OPCODE_DICT, OPCODE_TUPLE:
+		if t.dropMark[t.markOf[i]] {
+			items := t.markItems[i]
+			var code uint8
+			switch {
+			case ins.Opcode == OPCODE_LIST:
+				code = OPCODE_EMPTY_LIST
+			case ins.Opcode == OPCODE_DICT:
+				code = OPCODE_EMPTY_DICT
+			case items == 0:
+				code = OPCODE_EMPTY_TUPLE
+			default:
+				code = OPCODE_TUPLE1 + uint8(items-1)
+			}
+			_, err = w.Write([]byte{code})
+			return err
+		}
+
+	case OPCODE_APPENDS, OPCODE_SETITEMS:
+		if t.dropMark[t.markOf[i]] {
+			if t.markItems[i] == 0 {
+				return nil
+			}
+			code := uint8(OPCODE_APPEND)
+			if ins.Opcode == OPCODE_SETITEMS {
+				code = OPCODE_SETITEM
+			}
+			_, err = w.Write([]byte{code})
+			return err
+		}
+
+	case OPCODE_TUPLE1, OPCODE_TUPLE2, OPCODE_TUPLE3:
+		if t.protocol < 2 {
+			_, err = w.Write([]byte{OPCODE_TUPLE})
+			return err
+		}
+
+	case OPCODE_POP_MARK, OPCODE_OBJ, OPCODE_BINPERSID:
+		if t.protocol == 0 {
+			return t.notTranscodable(ins)
+		}
+
+	case OPCODE_NEWOBJ, OPCODE_EXT1, OPCODE_EXT2, OPCODE_EXT4:
+		if t.protocol < 2 {
+			return t.notTranscodable(ins)
+		}
+	}
+
+	//Everything else is the same in every protocol
+	_, err = w.Write(ins.raw)
+	return err
+}
+
+func (t *transcoder) writeBool(w io.Writer, v bool) error {
+	var err error
+	switch {
+	case t.protocol == 2 && v:
+		_, err = w.Write([]byte{OPCODE_NEWTRUE})
+	case t.protocol == 2:
+		_, err = w.Write([]byte{OPCODE_NEWFALSE})
+	case v:
+		_, err = fmt.Fprintf(w, "%c01\n", OPCODE_INT)
+	default:
+		_, err = fmt.Fprintf(w, "%c00\n", OPCODE_INT)
+	}
+	return err
+}
+
+func (t *transcoder) writeInt(w io.Writer, v int64) error {
+	var err error
+	switch {
+	case t.protocol == 0 || v > math.MaxInt32 || v < math.MinInt32:
+		//INT holds values that need a 64 bit Python int in any protocol
+		_, err = fmt.Fprintf(w, "%c%d\n", OPCODE_INT, v)
+	case v >= 0 && v < 1<<8:
+		_, err = w.Write([]byte{OPCODE_BININT1, uint8(v)})
+	case v >= 0 && v < 1<<16:
+		err = binary.Write(w, binary.LittleEndian, struct {
+			Opcode uint8
+			V      uint16
+		}{OPCODE_BININT2, uint16(v)})
+	default:
+		_, err = intProxy(v).writeTo(w)
+	}
+	return err
+}
+
+func (t *transcoder) writeString(w io.Writer, v string) error {
+	var err error
+	switch {
+	case t.protocol == 0:
+		_, err = fmt.Fprintf(w, "%c%s\n", OPCODE_STRING, quoteStringLiteral(v))
+	case len(v) < 256:
+		_, err = w.Write([]byte{OPCODE_SHORT_BINSTRING, uint8(len(v))})
+		if err == nil {
+			_, err = io.WriteString(w, v)
+		}
+	default:
+		err = binary.Write(w, binary.LittleEndian, struct {
+			Opcode uint8
+			Length int32
+		}{OPCODE_BINSTRING, int32(len(v))})
+		if err == nil {
+			_, err = io.WriteString(w, v)
+		}
+	}
+	return err
+}
+
+/*
+Quotes a string of bytes the same way Python 2 "repr" does, which is the
+argument format of the STRING opcode.
+*/
+func quoteStringLiteral(v string) string {
+	quote := byte('\'')
+	if strings.IndexByte(v, '\'') != -1 && strings.IndexByte(v, '"') == -1 {
+		quote = '"'
+	}
+
+	buf := make([]byte, 0, len(v)+2)
+	buf = append(buf, quote)
+	for i := 0; i != len(v); i++ {
+		c := v[i]
+		switch {
+		case c == quote || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, `\n`...)
+		case c == '\r':
+			buf = append(buf, `\r`...)
+		case c == '\t':
+			buf = append(buf, `\t`...)
+		case c < 0x20 || c >= 0x7f:
+			buf = append(buf, fmt.Sprintf(`\x%02x`, c)...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	buf = append(buf, quote)
+	return string(buf)
+}
+
+/*
+Encodes a string as "raw-unicode-escape" the same way the Python pickler
+does for the UNICODE opcode. The characters that would end the argument or
+be confused with an escape are always escaped.
+*/
+func encodeRawUnicodeEscape(v string) string {
+	buf := make([]byte, 0, len(v))
+	for _, r := range v {
+		switch {
+		case r == '\\' || r == '\n' || r == '\r' || r == 0 || r == 0x1a:
+			buf = append(buf, fmt.Sprintf(`\u%04x`, r)...)
+		case r < utf8.RuneSelf:
+			buf = append(buf, byte(r))
+		case r < 0x100:
+			buf = append(buf, byte(r))
+		case r < 0x10000:
+			buf = append(buf, fmt.Sprintf(`\u%04x`, r)...)
+		default:
+			buf = append(buf, fmt.Sprintf(`\U%08x`, r)...)
+		}
+	}
+	return string(buf)
+}
+
+/*
+Formats a float the same way Python "repr" does, which is the argument
+format of the FLOAT opcode.
+*/
+func formatFloatLiteral(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "nan"
+	}
+
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
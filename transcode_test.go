@@ -0,0 +1,127 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+The same list pickled by Python with each protocol. The second and
+third items are the same list object.
+*/
+var transcodeInputs = []string{
+	"(lp0\n(I1\nI2\nI3\ntp1\na(lp2\nI1\naag2\na(taV\xe9\\u20ac\np3\naL1180591620717411303424L\naF-1.5\naI01\na(dp4\nVk\np5\n(I7\ntp6\nsa.",
+	"]q\x00((K\x01K\x02K\x03tq\x01]q\x02K\x01ah\x02)X\x05\x00\x00\x00\xc3\xa9\xe2\x82\xacq\x03L1180591620717411303424L\nG\xbf\xf8\x00\x00\x00\x00\x00\x00I01\n}q\x04X\x01\x00\x00\x00kq\x05(K\x07tq\x06se.",
+	"\x80\x02]q\x00(K\x01K\x02K\x03\x87q\x01]q\x02K\x01ah\x02)X\x05\x00\x00\x00\xc3\xa9\xe2\x82\xacq\x03\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@G\xbf\xf8\x00\x00\x00\x00\x00\x00\x88}q\x04X\x01\x00\x00\x00kq\x05K\x07\x85q\x06se.",
+}
+
+func TestTranscode(t *testing.T) {
+	expect, err := Unpickle(strings.NewReader(transcodeInputs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for from, input := range transcodeInputs {
+		for to := 0; to != len(transcodeInputs); to++ {
+			buf := &bytes.Buffer{}
+			err := Transcode(buf, strings.NewReader(input), to)
+			if err != nil {
+				t.Fatalf("%d to %d: %v", from, to, err)
+			}
+
+			//Python batches APPEND into APPENDS, so only conversions
+			//from a binary protocol give the same bytes as Python
+			if from != 0 && buf.String() != transcodeInputs[to] {
+				t.Errorf("%d to %d: got %q expected %q", from, to, buf.String(), transcodeInputs[to])
+			}
+
+			d := NewDisassembler(bytes.NewReader(buf.Bytes()))
+			for err == nil {
+				_, err = d.Next()
+			}
+			if d.Protocol() != to {
+				t.Errorf("%d to %d: output uses opcodes from protocol %d", from, to, d.Protocol())
+			}
+
+			v, err := Unpickle(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%d to %d: %v", from, to, err)
+			}
+			if !reflect.DeepEqual(v, expect) {
+				t.Errorf("%d to %d: unpickled %v expected %v", from, to, v, expect)
+			}
+		}
+	}
+}
+
+func TestTranscodeStringEscapes(t *testing.T) {
+	const input = "(S'caf\\xc3\\xa9'\np0\nS\"it's\"\np1\nS'a\\'b\"\\\\\\n\\t\\x00\\xff'\np2\ng0\ntp3\n."
+
+	buf := &bytes.Buffer{}
+	err := Transcode(buf, strings.NewReader(input), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const binary = "\x80\x02(U\x05caf\xc3\xa9q\x00U\x04it'sq\x01U\ta'b\"\\\n\t\x00\xffq\x02h\x00tq\x03."
+	if buf.String() != binary {
+		t.Fatalf("Got %q expected %q", buf.String(), binary)
+	}
+
+	buf.Reset()
+	err = Transcode(buf, strings.NewReader(binary), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != input {
+		t.Fatalf("Got %q expected %q", buf.String(), input)
+	}
+}
+
+func TestTranscodeUnsupported(t *testing.T) {
+	const input = "\x80\x02c__main__\nFoo\nq\x00)\x81q\x01."
+	err := Transcode(&bytes.Buffer{}, strings.NewReader(input), 1)
+	te, ok := err.(TranscodingError)
+	if !ok {
+		t.Fatalf("Expected %T but got (%T)%v", te, err, err)
+	}
+	if te.Offset != 19 || te.Name != "NEWOBJ" || te.Protocol != 1 {
+		t.Fatalf("Got wrong error %v", te)
+	}
+
+	err = Transcode(&bytes.Buffer{}, strings.NewReader(input), 3)
+	if err == nil {
+		t.Fatal("Expected error for protocol 3")
+	}
+}
+
+func TestTranscodePopMark(t *testing.T) {
+	//Python 2 writes this for t = ([],); t[0].append(t)
+	const input = "((lp0\n(g0\ntp1\na00g1\n."
+	expect, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for to := 0; to != 3; to++ {
+		buf := &bytes.Buffer{}
+		err := Transcode(buf, strings.NewReader(input), to)
+		if err != nil {
+			t.Fatalf("To %d: %v", to, err)
+		}
+
+		result, err := Unpickle(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("To %d: %v", to, err)
+		}
+		if !reflect.DeepEqual(expect, result) {
+			t.Fatalf("To %d: got %v expected %v", to, result, expect)
+		}
+
+		//Python loads this the same as the input
+		if to == 2 && buf.String() != "\x80\x02(]q\x00h\x00\x85q\x01a00h\x01." {
+			t.Fatalf("Got %q", buf.String())
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package stalecucumber
 
 import "io"
+import "io/ioutil"
 import "reflect"
 import "fmt"
 import "errors"
-import "strings"
 import "math/big"
 import "bytes"
+import "database/sql"
+import "encoding"
+import "strconv"
 
 const PICKLE_TAG = "pickle"
 
@@ -14,13 +17,16 @@ type UnpackingError struct {
 	Source      interface{}
 	Destination reflect.Value
 	Err         error
+	Path        string
 }
 
 /*
 This type is returned when a call to From() fails.
 Setting "AllowMissingFields" and "AllowMismatchedFields"
 on the result of "UnpackInto" controls if this error is
-returned or not.
+returned or not. Path is where in the source the error
+happened, such as `orders[3].items["sku"].price`, and is
+empty for the source itself.
 */
 func (ue UnpackingError) Error() string {
 	var dv string
@@ -43,14 +49,41 @@ func (ue UnpackingError) Error() string {
 		dt = fmt.Sprintf("%s", ue.Destination.Type())
 	}
 
-	return fmt.Sprintf("Error unpacking %v(%T) into %s(%s):%v",
+	var at string
+	if len(ue.Path) != 0 {
+		at = " at " + ue.Path
+	}
+
+	return fmt.Sprintf("Error unpacking %v(%T)%s into %s(%s):%v",
 		ue.Source,
 		ue.Source,
+		at,
 		dv,
 		dt,
 		ue.Err)
 }
 
+func (ue UnpackingError) Unwrap() error {
+	return ue.Err
+}
+
+/*
+This type is returned by From() when "CollectErrors" is set and
+any part of the source could not be unpacked. It holds every error in
+the order they happened.
+*/
+type UnpackingErrors []UnpackingError
+
+func (ue UnpackingErrors) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d errors unpacking", len(ue))
+	for _, err := range ue {
+		buf.WriteString("\n")
+		buf.WriteString(err.Error())
+	}
+	return buf.String()
+}
+
 var ErrNilPointer = errors.New("Destination cannot be a nil pointer")
 var ErrNotPointer = errors.New("Destination must be a pointer type")
 var ErrTargetTypeNotPointer = errors.New("Target type must be a pointer to unpack this value")
@@ -61,6 +94,18 @@ type unpacker struct {
 	dest                  reflect.Value
 	AllowMissingFields    bool
 	AllowMismatchedFields bool
+	NameMapper            NameMapper
+	CollectErrors         bool
+	OnMismatch            func(err UnpackingError)
+	DecodeHooks           *DecodeHooks
+	Normalize             bool
+	Coerce                Coercion
+	StreamIntoWriters     bool
+	ZeroOnNone            bool
+	Classes               *ClassRegistry
+
+	path   *pathSegment
+	errors *[]UnpackingError
 }
 
 func UnpackInto(dest interface{}) unpacker {
@@ -75,7 +120,118 @@ func (u unpacker) From(srcI interface{}, err error) error {
 		return err
 	}
 
-	return u.from(srcI)
+	if !u.CollectErrors || u.errors != nil {
+		return u.from(srcI)
+	}
+
+	u.errors = new([]UnpackingError)
+	err = u.collect(u.from(srcI))
+	if err != nil {
+		return err
+	}
+	if len(*u.errors) != 0 {
+		return UnpackingErrors(*u.errors)
+	}
+	return nil
+}
+
+/*
+Returns a copy of the unpacker with the same options that unpacks into
+another destination.
+*/
+func (u unpacker) into(dest reflect.Value) unpacker {
+	u.dest = dest
+	return u
+}
+
+/*
+Returns a copy of the unpacker that unpacks the value of a key of a
+Python dict into a field of a struct.
+*/
+func (u unpacker) field(dest reflect.Value, key string) unpacker {
+	u.dest = dest
+	u.path = &pathSegment{parent: u.path, kind: pathField, field: key}
+	return u
+}
+
+/*
+Returns a copy of the unpacker that unpacks an item of a Python list or
+tuple.
+*/
+func (u unpacker) index(dest reflect.Value, i int) unpacker {
+	u.dest = dest
+	u.path = &pathSegment{parent: u.path, kind: pathIndex, index: i}
+	return u
+}
+
+/*
+Returns a copy of the unpacker that unpacks a key of a Python dict, or
+the value of that key, into a map.
+*/
+func (u unpacker) key(dest reflect.Value, k interface{}) unpacker {
+	u.dest = dest
+	u.path = &pathSegment{parent: u.path, kind: pathKey, key: k}
+	return u
+}
+
+func (u unpacker) error(src interface{}, err error) UnpackingError {
+	return UnpackingError{Source: src,
+		Destination: u.dest,
+		Err:         err,
+		Path:        u.path.String()}
+}
+
+/*
+A part of the path to the value being unpacked. The path is only
+formatted when an UnpackingError is created.
+*/
+type pathSegment struct {
+	parent *pathSegment
+	kind   uint8
+	field  string
+	index  int
+	key    interface{}
+}
+
+const (
+	pathField = iota
+	pathIndex
+	pathKey
+)
+
+func (p *pathSegment) String() string {
+	if p == nil {
+		return ""
+	}
+
+	prefix := p.parent.String()
+	switch p.kind {
+	case pathField:
+		if len(prefix) == 0 {
+			return p.field
+		}
+		return prefix + "." + p.field
+	case pathIndex:
+		return prefix + "[" + strconv.Itoa(p.index) + "]"
+	}
+	if s, ok := stringValue(p.key); ok {
+		return fmt.Sprintf("%s[%q]", prefix, s)
+	}
+	return fmt.Sprintf("%s[%v]", prefix, p.key)
+}
+
+/*
+Records an error from unpacking part of the source when errors are
+being collected, so that unpacking continues with the rest of it.
+Returns any other error unchanged.
+*/
+func (u unpacker) collect(err error) error {
+	ue, ok := err.(UnpackingError)
+	if !ok || u.errors == nil {
+		return err
+	}
+	*u.errors = append(*u.errors, ue)
+	return nil
 }
 
 func (u unpacker) from(srcI interface{}) error {
@@ -85,16 +241,12 @@ func (u unpacker) from(srcI interface{}) error {
 
 	//The destination must always be a pointer
 	if v.Kind() != reflect.Ptr {
-		return UnpackingError{Source: srcI,
-			Destination: u.dest,
-			Err:         ErrNotPointer}
+		return u.error(srcI, ErrNotPointer)
 	}
 
 	//The destination can never be nil
 	if v.IsNil() {
-		return UnpackingError{Source: srcI,
-			Destination: u.dest,
-			Err:         ErrNilPointer}
+		return u.error(srcI, ErrNilPointer)
 
 	}
 
@@ -108,12 +260,64 @@ func (u unpacker) from(srcI interface{}) error {
 		vIndirect = vIndirect.Elem()
 	}
 
+	//Hooks run before any other conversion
+	converted, ok, err := u.DecodeHooks.convert(srcI, vIndirect.Type())
+	if err != nil {
+		return u.error(srcI, err)
+	}
+	if ok {
+		cv := reflect.ValueOf(converted)
+		if cv.IsValid() && cv.Type().AssignableTo(vIndirect.Type()) {
+			vIndirect.Set(cv)
+			return nil
+		}
+		srcI = converted
+	}
+
+	//Instances of Python classes are unpacked into the
+	//Go type registered for the class
+	if object, ok := srcI.(*PythonObject); ok {
+		if handled, err := u.fromObject(object, vIndirect); handled {
+			return err
+		}
+	}
+
+	//Any value can be placed in an empty interface, such
+	//as the values of a map[string]interface{}
+	if vIndirect.Kind() == reflect.Interface && vIndirect.NumMethod() == 0 {
+		if u.Normalize {
+			srcI, _ = Normalize(srcI, nil)
+		}
+		//A nil source leaves the interface nil. Taking the address
+		//of srcI instead would move it to the heap on every call
+		if srcI == nil {
+			vIndirect.Set(reflect.Zero(vIndirect.Type()))
+		} else {
+			vIndirect.Set(reflect.ValueOf(srcI))
+		}
+		return nil
+	}
+
+	//Types such as sql.NullString accept values and None. Other
+	//types with a Scan method, such as a slice, are filled from
+	//lists and dicts by the rules below
+	scanner, isScanner := vIndirect.Addr().Interface().(sql.Scanner)
+	isScanner = isScanner && (isScalar(srcI) || isSQLNullType(vIndirect.Type()))
+	if _, isNone := srcI.(PickleNone); isScanner && !isNone {
+		return u.scan(scanner, srcI)
+	}
+
 	//Check the input against known types
 	switch s := srcI.(type) {
 	default:
-		return UnpackingError{Source: srcI,
-			Destination: u.dest,
-			Err:         errors.New("Unknown source type")}
+		//Tuples used as keys are arrays
+		if items, ok := tupleKeyItems(srcI); ok {
+			return u.from(items)
+		}
+		if set, ok := srcI.(PickleFrozenSetKey); ok {
+			return u.from(set.Set())
+		}
+		return u.error(srcI, errors.New("Unknown source type"))
 	case PickleNone:
 		vElem := v.Elem()
 		for vElem.Kind() == reflect.Ptr {
@@ -129,25 +333,28 @@ func (u unpacker) from(srcI interface{}) error {
 			}
 		}
 
-		return UnpackingError{Source: srcI,
-			Destination: u.dest,
-			Err:         ErrTargetTypeNotPointer}
+		if isScanner {
+			return u.scan(scanner, srcI)
+		}
+
+		if u.ZeroOnNone {
+			vIndirect.Set(reflect.Zero(vIndirect.Type()))
+			return nil
+		}
+
+		return u.error(srcI, ErrTargetTypeNotPointer)
 
 	case int64:
 		switch vIndirect.Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int64, reflect.Int32:
 			if vIndirect.OverflowInt(s) {
-				return UnpackingError{Source: srcI,
-					Destination: u.dest,
-					Err:         ErrTargetTypeOverflow}
+				return u.error(srcI, ErrTargetTypeOverflow)
 			}
 			vIndirect.SetInt(s)
 			return nil
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			if s < 0 || vIndirect.OverflowUint(uint64(s)) {
-				return UnpackingError{Source: srcI,
-					Destination: u.dest,
-					Err:         ErrTargetTypeOverflow}
+				return u.error(srcI, ErrTargetTypeOverflow)
 			}
 
 			vIndirect.SetUint(uint64(s))
@@ -166,6 +373,50 @@ func (u unpacker) from(srcI interface{}) error {
 			vIndirect.SetString(s)
 			return nil
 		}
+
+		if ok, err := u.fromBytes(srcI, []byte(s), vIndirect); ok {
+			return err
+		}
+	case PickleBytes:
+		switch vIndirect.Kind() {
+		case reflect.String:
+			vIndirect.SetString(string(s))
+			return nil
+		}
+
+		if ok, err := u.fromBytes(srcI, []byte(s), vIndirect); ok {
+			return err
+		}
+	case PickleByteArray:
+		//Always copy so the destination does not share the source
+		raw := append([]byte(nil), s...)
+		if ok, err := u.fromBytes(srcI, raw, vIndirect); ok {
+			return err
+		}
+	case PickleSet, PickleFrozenSet:
+		srcV := reflect.ValueOf(s)
+		if srcV.Type().AssignableTo(vIndirect.Type()) {
+			vIndirect.Set(srcV)
+			return nil
+		}
+
+		//Allow a map[interface{}]bool or any other type
+		//with the same underlying type
+		if vIndirect.Kind() == reflect.Map && srcV.Type().ConvertibleTo(vIndirect.Type()) {
+			vIndirect.Set(srcV.Convert(vIndirect.Type()))
+			return nil
+		}
+
+		switch vIndirect.Kind() {
+		case reflect.Map:
+			elemT := vIndirect.Type().Elem()
+			if elemT.Kind() == reflect.Bool ||
+				(elemT.Kind() == reflect.Struct && elemT.NumField() == 0) {
+				return u.unpackSetIntoMap(srcV, vIndirect)
+			}
+		case reflect.Slice:
+			return u.unpackSetIntoSlice(srcV, vIndirect)
+		}
 	case bool:
 		switch vIndirect.Kind() {
 		case reflect.Bool:
@@ -178,6 +429,34 @@ func (u unpacker) from(srcI interface{}) error {
 			vIndirect.SetFloat(s)
 			return nil
 		}
+	case float32:
+		return u.into(v).From(float64(s), nil)
+	case *big.Float:
+		dstBig, ok := vIndirect.Addr().Interface().(*big.Float)
+		if ok {
+			dstBig.Set(s)
+			return nil
+		}
+
+		f, _ := s.Float64()
+		return u.into(v).From(f, nil)
+	case PickleNumber:
+		if vIndirect.Kind() == reflect.String {
+			vIndirect.SetString(string(s))
+			return nil
+		}
+
+		var n interface{}
+		if i, err := s.Int64(); err == nil {
+			n = i
+		} else if i, err := s.BigInt(); err == nil {
+			n = i
+		} else if f, err := s.Float64(); err == nil {
+			n = f
+		} else {
+			break
+		}
+		return u.into(v).From(n, nil)
 	case *big.Int:
 		dstBig, ok := vIndirect.Addr().Interface().(*big.Int)
 		if ok {
@@ -186,41 +465,49 @@ func (u unpacker) from(srcI interface{}) error {
 		}
 
 		if vi, err := Int(srcI, nil); err == nil {
-			return unpacker{dest: v,
-				AllowMismatchedFields: u.AllowMismatchedFields,
-				AllowMissingFields:    u.AllowMissingFields}.From(vi, nil)
+			return u.into(v).From(vi, nil)
 		}
 
 	case io.Reader:		
-		var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
 		// Check for exact match
 		if vIndirect.Kind() == reflect.Interface && vIndirect.Type().Implements(readerType) { 
 			vIndirect.Set(reflect.ValueOf(s))
 			return nil
 		}
 
-		// Handle converting io.Reader to byte buffer if the caller passes that in
-		var byteBufferType = reflect.TypeOf((*bytes.Buffer)(nil)).Elem()
-		if vIndirect.Type() == byteBufferType {
-			buf := &bytes.Buffer{}
-			_, err := io.Copy(buf, s)
+		//Copy straight into a writer without buffering
+		if w, ok := u.writer(vIndirect); ok {
+			_, err := io.Copy(w, s)
 			if err != nil {
-				return err
+				return u.error(srcI, err)
 			}
-
-			vIndirect.Set(reflect.ValueOf(*buf))
 			return nil
-		}		
+		}
+
+		if isBytesDestination(vIndirect.Type()) {
+			raw, err := ioutil.ReadAll(s)
+			if err != nil {
+				return u.error(srcI, err)
+			}
+			_, err = u.fromBytes(srcI, raw, vIndirect)
+			return err
+		}
 		
 	case []interface{}:
+		//Fill arrays and structs that are tuples in order
+		if vIndirect.Kind() == reflect.Array ||
+			(vIndirect.Kind() == reflect.Struct && isTupleStruct(vIndirect.Type())) {
+			return u.fromTuple(s, vIndirect)
+		}
+
 		//Check that the destination is a slice
 		if vIndirect.Kind() != reflect.Slice {
-			return UnpackingError{Source: s,
-				Destination: u.dest,
-				Err:         fmt.Errorf("Cannot unpack slice into destination")}
+			return u.error(s, fmt.Errorf("Cannot unpack slice into destination"))
 		}
-		//Check for exact type match
-		if vIndirect.Type().Elem().Kind() == reflect.Interface {
+		//Check for exact type match. When normalizing or
+		//creating classes each item is unpacked so that it
+		//is converted
+		if vIndirect.Type().Elem() == interfaceType && !u.Normalize && u.Classes == nil {
 			vIndirect.Set(reflect.ValueOf(s))
 			return nil
 		}
@@ -238,10 +525,7 @@ func (u unpacker) from(srcI interface{}) error {
 			dstV := replacement.Index(i)
 
 			//Recurse to set the value
-			err := unpacker{dest: dstV.Addr(),
-				AllowMissingFields:    u.AllowMissingFields,
-				AllowMismatchedFields: u.AllowMismatchedFields}.
-				From(srcV, nil)
+			err := u.collect(u.index(dstV.Addr(), i).From(srcV, nil))
 			if err != nil {
 				return err
 			}
@@ -254,91 +538,100 @@ func (u unpacker) from(srcI interface{}) error {
 		//of the type
 		if vIndirect.Kind() == reflect.Map {
 			dstT := vIndirect.Type()
-			if dstT.Key().Kind() == reflect.Interface &&
-				dstT.Elem().Kind() == reflect.Interface {
+			if dstT.Key() == interfaceType && dstT.Elem() == interfaceType &&
+				!u.Normalize && u.Classes == nil {
 				vIndirect.Set(reflect.ValueOf(s))
 				return nil
 			}
+
+			return u.unpackMap(s, vIndirect)
 		}
 
 		var src map[string]interface{}
 		var err error
 		src, err = DictString(srcI, err)
 		if err != nil {
-			return UnpackingError{Source: srcI,
-				Destination: u.dest,
-				Err:         fmt.Errorf("Cannot unpack source into struct")}
+			return u.error(srcI, fmt.Errorf("Cannot unpack source into struct"))
 		}
 
 		if vIndirect.Kind() != reflect.Struct {
-			return UnpackingError{Source: src,
-				Destination: u.dest,
-				Err:         fmt.Errorf("Cannot unpack into %v", v.Kind().String())}
-
-		}
-
-		var fieldByTag map[string]int
-		vIndirectType := reflect.TypeOf(vIndirect.Interface())
-		numFields := vIndirectType.NumField()
-
-		for i := 0; i != numFields; i++ {
-			fv := vIndirectType.Field(i)
-			tag := fv.Tag.Get(PICKLE_TAG)
+			return u.error(src, fmt.Errorf("Cannot unpack into %v", v.Kind().String()))
 
-			if len(tag) != 0 {
-				if fieldByTag == nil {
-					fieldByTag = make(map[string]int)
-				}
-				fieldByTag[tag] = i
-			}
 		}
 
+		fields, remain := structFields(vIndirect.Type(), u.NameMapper)
+		found := make([]bool, len(fields))
 		for k, kv := range src {
-			var fv reflect.Value
-
-			if fieldIndex, ok := fieldByTag[k]; ok {
-				fv = vIndirect.Field(fieldIndex)
-			} else {
-				//Try the name verbatim. This catches
-				//embedded fields as well
-				fv = vIndirect.FieldByName(k)
-
-				if !fv.IsValid() {
-					//Capitalize the first character. Structs
-					//do not export fields with a lower case
-					//first character
-					capk := strings.ToUpper(k[0:1]) + k[1:]
-					fv = vIndirect.FieldByName(capk)
+			var field structField
+			if i := findStructField(fields, k, u.NameMapper); i != -1 {
+				found[i] = true
+				field = fields[i]
+			} else if promoted, ok := promotedField(vIndirect.Type(), k, u.NameMapper); ok {
+				field = promoted
+			} else if remain != nil {
+				err := u.collect(u.field(vIndirect.FieldByIndex(remain), k).fromRemain(k, kv))
+				if err != nil {
+					return err
 				}
-			}
-
-			if !fv.IsValid() || !fv.CanSet() {
+				continue
+			} else {
 				if !u.AllowMissingFields {
-					return UnpackingError{Source: src,
-						Destination: u.dest,
-						Err:         fmt.Errorf("Cannot find field for key %q", k)}
+					err := u.collect(u.error(src, fmt.Errorf("Cannot find field for key %q", k)))
+					if err != nil {
+						return err
+					}
 				}
 				continue
 			}
+			fv := vIndirect.FieldByIndex(field.index)
 
-			err := unpacker{dest: fv.Addr(),
-				AllowMismatchedFields: u.AllowMismatchedFields,
-				AllowMissingFields:    u.AllowMissingFields}.from(kv)
+			var err error
+			if text, ok := stringValue(kv); ok && field.asString {
+				err = u.field(fv.Addr(), k).fromText(text)
+			} else {
+				err = u.field(fv.Addr(), k).from(kv)
+			}
 
 			if err != nil {
 				if u.AllowMismatchedFields {
 					if unpackingError, ok := err.(UnpackingError); ok {
-						switch unpackingError.Err {
-						case ErrTargetTypeOverflow,
-							ErrTargetTypeNotPointer,
-							ErrTargetTypeMismatch:
+						switch {
+						case errors.Is(unpackingError.Err, ErrTargetTypeOverflow),
+							errors.Is(unpackingError.Err, ErrTargetTypeNotPointer),
+							errors.Is(unpackingError.Err, ErrTargetTypeMismatch):
 
 							fv.Set(reflect.Zero(fv.Type()))
+							if u.OnMismatch != nil {
+								u.OnMismatch(unpackingError)
+							}
 							continue
 						}
 
 					}
 				}
+
+				err = u.collect(err)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, field := range fields {
+			if found[i] {
+				continue
+			}
+
+			var err error
+			if field.required {
+				err = u.error(src, fmt.Errorf("Missing required key %q", field.name))
+			} else if field.hasDefault {
+				fv := vIndirect.FieldByIndex(field.index)
+				err = u.field(fv.Addr(), field.name).fromText(field.defaultValue)
+			}
+
+			err = u.collect(err)
+			if err != nil {
 				return err
 			}
 		}
@@ -346,7 +639,495 @@ func (u unpacker) from(srcI interface{}) error {
 		return nil
 	}
 
-	return UnpackingError{Source: srcI,
-		Destination: u.dest,
-		Err:         ErrTargetTypeMismatch}
+	if u.Coerce != 0 {
+		coerced, ok, err := u.coerce(srcI, vIndirect.Type())
+		if err != nil {
+			return u.error(srcI, err)
+		}
+		if ok {
+			return u.from(coerced)
+		}
+	}
+
+	return u.error(srcI, ErrTargetTypeMismatch)
+}
+
+/*
+Unpacks an instance of a Python class. An interface is set to a new
+value of the Go type registered for the class, which the attributes of
+the instance are unpacked into. Any other destination has the
+attributes unpacked into it directly. Returns false if the instance
+should be placed in an empty interface as it is.
+*/
+func (u unpacker) fromObject(object *PythonObject, dst reflect.Value) (bool, error) {
+	dstT := dst.Type()
+	if dstT == reflect.TypeOf(*object) {
+		dst.Set(reflect.ValueOf(*object))
+		return true, nil
+	}
+
+	attributes, ok := objectAttributes(object)
+	if !ok {
+		if dstT.Kind() == reflect.Interface && dstT.NumMethod() == 0 {
+			return false, nil
+		}
+		return true, u.error(object, fmt.Errorf("%w: cannot unpack the state of %v", ErrTargetTypeMismatch, object.Class))
+	}
+
+	if dstT.Kind() != reflect.Interface {
+		return true, u.from(attributes)
+	}
+
+	t, ok := u.Classes.goType(object.Class)
+	if !ok {
+		if dstT.NumMethod() == 0 {
+			return false, nil
+		}
+		return true, u.error(object, fmt.Errorf("%w: no Go type is registered for %v", ErrTargetTypeMismatch, object.Class))
+	}
+	if !t.AssignableTo(dstT) {
+		return true, u.error(object, fmt.Errorf("%w: %v does not implement %v", ErrTargetTypeMismatch, t, dstT))
+	}
+
+	var value reflect.Value
+	if t.Kind() == reflect.Ptr {
+		value = reflect.New(t.Elem())
+		err := u.into(value).from(attributes)
+		if err != nil {
+			return true, err
+		}
+	} else {
+		ptr := reflect.New(t)
+		err := u.into(ptr).from(attributes)
+		if err != nil {
+			return true, err
+		}
+		value = ptr.Elem()
+	}
+
+	dst.Set(value)
+	return true, nil
+}
+
+/*
+Unpacks a value into a sql.Scanner, such as sql.NullString. Python None
+is scanned as nil, bytes as []byte and longs that fit as int64. An error
+from Scan is a mismatch.
+*/
+func (u unpacker) scan(scanner sql.Scanner, srcI interface{}) error {
+	var value interface{}
+	switch s := srcI.(type) {
+	case PickleNone:
+		value = nil
+	case PickleBytes:
+		value = []byte(s)
+	case PickleByteArray:
+		value = append([]byte(nil), s...)
+	case float32:
+		value = float64(s)
+	case *big.Int:
+		if !s.IsInt64() {
+			return u.error(srcI, ErrTargetTypeOverflow)
+		}
+		value = s.Int64()
+	default:
+		value = srcI
+	}
+
+	err := scanner.Scan(value)
+	if err != nil {
+		return u.error(srcI, fmt.Errorf("%w: %v", ErrTargetTypeMismatch, err))
+	}
+	return nil
+}
+
+/*
+Reports if a value is None or a single value, such as a number, string
+or bytes, rather than a container.
+*/
+func isScalar(srcI interface{}) bool {
+	switch srcI.(type) {
+	case PickleNone, bool, int64, *big.Int, float32, float64, *big.Float,
+		string, PickleBytes, PickleByteArray, PickleNumber:
+		return true
+	}
+	return false
+}
+
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+var bytesReaderType = reflect.TypeOf((*bytes.Reader)(nil))
+var bytesBufferPtrType = reflect.TypeOf((*bytes.Buffer)(nil))
+var bytesBufferType = bytesBufferPtrType.Elem()
+
+/*
+Reports if binary data can be unpacked into a type. These are []byte,
+[N]byte, bytes.Buffer and interfaces such as io.Reader and io.Writer
+that are satisfied by *bytes.Reader or *bytes.Buffer.
+*/
+func isBytesDestination(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Interface:
+		return bytesReaderType.AssignableTo(t) || bytesBufferPtrType.AssignableTo(t)
+	}
+	return t == bytesBufferType
+}
+
+/*
+Returns the io.Writer that binary data is written to when
+"StreamIntoWriters" is set. This is the value of a destination that is
+an interface, if it is not nil and implements io.Writer.
+*/
+func (u unpacker) writer(dst reflect.Value) (io.Writer, bool) {
+	if !u.StreamIntoWriters || dst.Kind() != reflect.Interface || dst.IsNil() {
+		return nil, false
+	}
+	w, ok := dst.Interface().(io.Writer)
+	return w, ok
+}
+
+/*
+Unpacks binary data from a Python str, bytes or bytearray. The
+destination does not share raw with any other value. Returns false if
+the destination can not hold binary data.
+*/
+func (u unpacker) fromBytes(src interface{}, raw []byte, dst reflect.Value) (bool, error) {
+	if w, ok := u.writer(dst); ok {
+		_, err := w.Write(raw)
+		if err != nil {
+			return true, u.error(src, err)
+		}
+		return true, nil
+	}
+
+	dstT := dst.Type()
+	if !isBytesDestination(dstT) {
+		return false, nil
+	}
+
+	switch dstT.Kind() {
+	case reflect.Slice:
+		dst.SetBytes(raw)
+	case reflect.Array:
+		//The length must match, the same as for tuples, so
+		//that a truncated hash or key is not hidden
+		if len(raw) > dst.Len() {
+			return true, u.error(src, ErrTargetTypeOverflow)
+		}
+		if len(raw) < dst.Len() {
+			return true, u.error(src, fmt.Errorf("%w: cannot unpack %d bytes into %v", ErrTargetTypeMismatch, len(raw), dstT))
+		}
+		reflect.Copy(dst, reflect.ValueOf(raw))
+	case reflect.Interface:
+		if bytesReaderType.AssignableTo(dstT) {
+			dst.Set(reflect.ValueOf(bytes.NewReader(raw)))
+		} else {
+			dst.Set(reflect.ValueOf(bytes.NewBuffer(raw)))
+		}
+	default:
+		dst.Set(reflect.ValueOf(bytes.NewBuffer(raw)).Elem())
+	}
+	return true, nil
+}
+
+/*
+Unpacks a Python dict into a Go map of any type. Each key and value is
+converted the same way as any other value. Python tuples can be
+unpacked into keys that are structs or arrays, with one item for each
+exported field or element. Python strings can be unpacked into keys
+that implement encoding.TextUnmarshaler.
+*/
+func (u unpacker) unpackMap(src map[interface{}]interface{}, dst reflect.Value) error {
+	dstT := dst.Type()
+	result := dst
+	if result.IsNil() {
+		result = reflect.MakeMapWithSize(dstT, len(src))
+	}
+
+	for k, kv := range src {
+		key := reflect.New(dstT.Key())
+		err := u.key(key, k).unpackKey(k)
+		if err == nil {
+			value := reflect.New(dstT.Elem())
+			err = u.key(value, k).from(kv)
+			if err == nil {
+				result.SetMapIndex(key.Elem(), value.Elem())
+				continue
+			}
+		}
+
+		err = u.collect(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	dst.Set(result)
+	return nil
+}
+
+func (u unpacker) unpackKey(k interface{}) error {
+	if tu, ok := u.dest.Interface().(encoding.TextUnmarshaler); ok {
+		var text string
+		switch s := k.(type) {
+		case string:
+			text = s
+		case PickleBytes:
+			text = string(s)
+		default:
+			ok = false
+		}
+
+		if ok {
+			err := tu.UnmarshalText([]byte(text))
+			if err != nil {
+				return u.error(k, err)
+			}
+			return nil
+		}
+	}
+
+	items, ok := tupleKeyItems(k)
+	if !ok {
+		return u.from(k)
+	}
+
+	keyKind := u.dest.Type().Elem().Kind()
+	if keyKind == reflect.Struct || keyKind == reflect.Array {
+		return u.fromTuple(items, u.dest.Elem())
+	}
+	return u.from(items)
+}
+
+/*
+Unpacks a Python set into the keys of a map such as map[T]struct{} or
+map[T]bool. Each item is converted the same way as the key of a dict.
+*/
+func (u unpacker) unpackSetIntoMap(src reflect.Value, dst reflect.Value) error {
+	dstT := dst.Type()
+	result := dst
+	if result.IsNil() {
+		result = reflect.MakeMapWithSize(dstT, src.Len())
+	}
+
+	present := reflect.New(dstT.Elem()).Elem()
+	if present.Kind() == reflect.Bool {
+		present.SetBool(true)
+	}
+
+	for _, item := range src.MapKeys() {
+		key := reflect.New(dstT.Key())
+		err := u.key(key, item.Interface()).unpackKey(item.Interface())
+		if err == nil {
+			result.SetMapIndex(key.Elem(), present)
+			continue
+		}
+
+		err = u.collect(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	dst.Set(result)
+	return nil
+}
+
+/*
+Unpacks a Python set into a slice. Sets are not ordered, so neither are
+the elements of the slice.
+*/
+func (u unpacker) unpackSetIntoSlice(src reflect.Value, dst reflect.Value) error {
+	items := src.MapKeys()
+	result := reflect.MakeSlice(dst.Type(), len(items), len(items))
+	for i, item := range items {
+		err := u.collect(u.key(result.Index(i).Addr(), item.Interface()).from(item.Interface()))
+		if err != nil {
+			return err
+		}
+	}
+
+	dst.Set(result)
+	return nil
+}
+
+/*
+Unpacks the items of a Python tuple or list into the elements of an
+array or the exported fields of a struct, in order. The number of items
+must match.
+*/
+func (u unpacker) fromTuple(src []interface{}, dst reflect.Value) error {
+	var targets []reflect.Value
+	switch dst.Kind() {
+	case reflect.Array:
+		for i := 0; i != dst.Len(); i++ {
+			targets = append(targets, dst.Index(i))
+		}
+	case reflect.Struct:
+		targets = tupleFields(dst)
+	}
+
+	if len(targets) != len(src) {
+		return u.error(src, fmt.Errorf("Cannot unpack tuple of length %d into %d values", len(src), len(targets)))
+	}
+
+	for i, target := range targets {
+		err := u.collect(u.index(target.Addr(), i).from(src[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Returns the index of the field for a key of a Python dict, or -1. The
+key is compared to the pickled name of each field first and then passed
+to the mapper with the Go name of each field.
+*/
+func findStructField(fields []structField, k string, mapper NameMapper) int {
+	if i := findStructFieldByName(fields, k); i != -1 {
+		return i
+	}
+
+	if mapper == nil {
+		mapper = DefaultNameMapper{}
+	}
+	for i, field := range fields {
+		if mapper.KeyMatches(k, field.goName) {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Finds the field for a key among the fields of exported embedded structs
+that are not inline. These are pickled as a nested dict, but a key in
+the outer dict is still unpacked into a field promoted from them, the
+same as FieldByName finds it. Embedded pointers are not followed.
+*/
+func promotedField(t reflect.Type, k string, mapper NameMapper) (structField, bool) {
+	for i := 0; i != t.NumField(); i++ {
+		embedded := t.Field(i)
+		if !embedded.Anonymous || embedded.Type.Kind() != reflect.Struct {
+			continue
+		}
+		tag := embedded.Tag.Get(PICKLE_TAG)
+		if tag == "-" {
+			continue
+		}
+
+		//The fields of embedded structs that are flattened are
+		//already fields of t, but structs embedded in them are not
+		_, options := parseTag(tag)
+		flattened := len(embedded.PkgPath) != 0 || options.Contains("inline")
+		if !flattened {
+			fields, _ := structFields(embedded.Type, mapper)
+			if j := findStructField(fields, k, mapper); j != -1 {
+				field := fields[j]
+				field.index = append([]int{i}, field.index...)
+				return field, true
+			}
+		}
+
+		if field, ok := promotedField(embedded.Type, k, mapper); ok {
+			field.index = append([]int{i}, field.index...)
+			return field, true
+		}
+	}
+	return structField{}, false
+}
+
+func findStructFieldByName(fields []structField, name string) int {
+	for i, field := range fields {
+		if field.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Places a key of a Python dict that does not belong to any field into
+the map of the field with the "remain" option. The value is unpacked
+into the element type of the map.
+*/
+func (u unpacker) fromRemain(k string, kv interface{}) error {
+	remain := u.dest
+	remainT := remain.Type()
+	if remainT.Key().Kind() != reflect.String && remainT.Key().Kind() != reflect.Interface {
+		return u.error(k, fmt.Errorf("Cannot place key %q in %s", k, remainT))
+	}
+	if remain.IsNil() {
+		remain.Set(reflect.MakeMap(remainT))
+	}
+
+	value := reflect.New(remainT.Elem())
+	err := u.into(value).from(kv)
+	if err != nil {
+		return err
+	}
+
+	key := reflect.New(remainT.Key()).Elem()
+	if key.Kind() == reflect.String {
+		key.SetString(k)
+	} else {
+		key.Set(reflect.ValueOf(k))
+	}
+	remain.SetMapIndex(key, value.Elem())
+	return nil
+}
+
+func stringValue(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case PickleBytes:
+		return string(s), true
+	}
+	return "", false
+}
+
+/*
+Unpacks a number or bool written as text. This is used for fields with
+the "string" option and for the "default=" option. Text is unpacked
+as a string into any other type.
+*/
+func (u unpacker) fromText(text string) error {
+	t := u.dest.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var v interface{}
+	var err error
+	switch t.Kind() {
+	case reflect.Bool:
+		v, err = strconv.ParseBool(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err = strconv.ParseInt(text, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(text, 10, 64)
+		v = new(big.Int).SetUint64(n)
+	case reflect.Float32, reflect.Float64:
+		v, err = strconv.ParseFloat(text, 64)
+	default:
+		if t == reflect.TypeOf(big.Int{}) {
+			n, ok := new(big.Int).SetString(text, 10)
+			if !ok {
+				err = fmt.Errorf("Cannot parse %q as an integer", text)
+			}
+			v = n
+		} else {
+			v = text
+		}
+	}
+
+	if err != nil {
+		return u.error(text, err)
+	}
+	return u.from(v)
 }
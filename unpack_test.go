@@ -1,12 +1,16 @@
 package stalecucumber
 
 import "io"
+import "io/ioutil"
 import "testing"
 import "strings"
 import "reflect"
 import "math/big"
 import "github.com/hydrogen18/stalecucumber/struct_export_test"
 import "bytes"
+import "errors"
+import "database/sql"
+import "sort"
 
 func BenchmarkUnpickleInt(b *testing.B) {
 	const protocol2Int = "\x80\x02K*."
@@ -82,6 +86,32 @@ func BenchmarkUnpickleDict(b *testing.B) {
 	}
 }
 
+func BenchmarkUnpackStructs(b *testing.B) {
+	type Item struct {
+		Name  string
+		Count int
+		Ratio float64
+	}
+
+	src := make([]interface{}, 1000)
+	for i := range src {
+		src[i] = map[interface{}]interface{}{
+			"Name":  "item",
+			"Count": int64(i),
+			"Ratio": 0.5,
+		}
+	}
+
+	b.ReportAllocs()
+	for i := 0; i != b.N; i++ {
+		var dst []Item
+		err := UnpackInto(&dst).From(src, nil)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func TestUnpackMapIntoStructHiddenField(t *testing.T) {
 
 	m := make(map[interface{}]interface{})
@@ -318,7 +348,8 @@ func TestUnpackIntoStructWithPointer(t *testing.T) {
 
 	expectedError := UnpackingError{Err: ErrTargetTypeNotPointer,
 		Destination: reflect.ValueOf(&dstWithoutPointer.C),
-		Source:      PickleNone{}}
+		Source:      PickleNone{},
+		Path:        "C"}
 	if !reflect.DeepEqual(err, expectedError) {
 		t.Fatalf("\n%v\n%v\n", err, expectedError)
 	}
@@ -757,22 +788,897 @@ func TestUnpackByteArrayAsPartOfMap(t *testing.T){
 		t.Fatal("dst does not contain key")
 	}
 
-	valReader, ok := val.(io.Reader)
+	buf, ok := val.(PickleByteArray)
+	if !ok {
+		t.Fatal("value is not a bytearray")
+	}
+
+	if string(buf) != magic {
+		t.Fatal("did not get magic value back")
+	}
+}
+
+
+
+ 
+func TestUnpackIntoTypedMaps(t *testing.T) {
+	floats := make(map[int]float64)
+	err := UnpackInto(&floats).From(Unpickle(strings.NewReader("(dp0\nI1\nF1.5\nsI2\nF2.5\ns.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(floats, map[int]float64{1: 1.5, 2: 2.5}) {
+		t.Fatalf("Got %v", floats)
+	}
+
+	type user struct {
+		Name string
+		Age  uint8
+	}
+	var users map[string]*user
+	const input = "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01}q\x02(X\x04\x00\x00\x00Nameq\x03X\x01\x00\x00\x00xq\x04X\x03\x00\x00\x00Ageq\x05K\x03uX\x01\x00\x00\x00bq\x06}q\x07(h\x03X\x01\x00\x00\x00yq\x08h\x05K\x04uu."
+	err = UnpackInto(&users).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || *users["a"] != (user{"x", 3}) || *users["b"] != (user{"y", 4}) {
+		t.Fatalf("Got %v", users)
+	}
+
+	var values map[string]interface{}
+	err = UnpackInto(&values).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || !reflect.DeepEqual(values["b"], map[interface{}]interface{}{"Name": "y", "Age": int64(4)}) {
+		t.Fatalf("Got %v", values)
+	}
+
+	var mismatched map[string]int
+	err = UnpackInto(&mismatched).From(Unpickle(strings.NewReader(input)))
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+}
+
+func TestUnpackTupleKeys(t *testing.T) {
+	const input = "\x80\x02}q\x00(K\x01X\x01\x00\x00\x00aq\x01\x86q\x02X\x03\x00\x00\x00oneq\x03K\x02X\x01\x00\x00\x00bq\x04\x86q\x05X\x03\x00\x00\x00twoq\x06u."
+
+	v, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[interface{}]interface{}{
+		[2]interface{}{int64(1), "a"}: "one",
+		[2]interface{}{int64(2), "b"}: "two",
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Fatalf("Got %v expected %v", v, expect)
+	}
+
+	type key struct {
+		ID   int
+		Name string
+	}
+	var byStruct map[key]string
+	err = UnpackInto(&byStruct).From(v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(byStruct, map[key]string{{1, "a"}: "one", {2, "b"}: "two"}) {
+		t.Fatalf("Got %v", byStruct)
+	}
+
+	var byArray map[[2]interface{}]string
+	err = UnpackInto(&byArray).From(v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byArray) != 2 || byArray[[2]interface{}{int64(2), "b"}] != "two" {
+		t.Fatalf("Got %v", byArray)
+	}
+
+	var tooShort map[[1]int]string
+	err = UnpackInto(&tooShort).From(v, nil)
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+
+	nested, err := Unpickle(strings.NewReader("(dp0\n(I1\n(I2\nI3\ntp1\ntp2\nVx\np3\ns."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[interface{}]interface{}{
+		[2]interface{}{int64(1), [2]interface{}{int64(2), int64(3)}}: "x",
+	}
+	if !reflect.DeepEqual(nested, expect) {
+		t.Fatalf("Got %v expected %v", nested, expect)
+	}
+}
+
+type upperKey string
+
+func (k *upperKey) UnmarshalText(text []byte) error {
+	*k = upperKey(strings.ToUpper(string(text)))
+	return nil
+}
+
+func TestUnpackTextUnmarshalerKeys(t *testing.T) {
+	var dst map[upperKey]bool
+	err := UnpackInto(&dst).From(Unpickle(strings.NewReader("\x80\x02}q\x00X\x07\x00\x00\x00abc.defq\x01\x88s.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, map[upperKey]bool{"ABC.DEF": true}) {
+		t.Fatalf("Got %v", dst)
+	}
+}
+
+func TestUnpackTupleIntoStruct(t *testing.T) {
+	const input = "\x80\x02K\x03X\x04\x00\x00\x00Fizzq\x00G?\xf8\x00\x00\x00\x00\x00\x00\x87q\x01."
+
+	var dst struct {
+		_     struct{} `pickle:",tuple"`
+		ID    int
+		Name  string
+		Score float64
+	}
+	err := UnpackInto(&dst).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.ID != 3 || dst.Name != "Fizz" || dst.Score != 1.5 {
+		t.Fatalf("Got %v", dst)
+	}
+
+	var short struct {
+		_  struct{} `pickle:",tuple"`
+		ID int
+	}
+	err = UnpackInto(&short).From(Unpickle(strings.NewReader(input)))
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+
+	//Without the tuple option a struct can not be filled
+	var untagged struct {
+		ID    int
+		Name  string
+		Score float64
+	}
+	err = UnpackInto(&untagged).From(Unpickle(strings.NewReader(input)))
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+}
+
+func TestUnpackIntoArrays(t *testing.T) {
+	const input = "(lp0\n(lp1\nF1.0\naF2.0\naF3.0\naa(lp2\nF4.0\naF5.0\naF6.0\naa."
+
+	var dst [][3]float64
+	err := UnpackInto(&dst).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, [][3]float64{{1, 2, 3}, {4, 5, 6}}) {
+		t.Fatalf("Got %v", dst)
+	}
+
+	var wrongLength [][2]float64
+	err = UnpackInto(&wrongLength).From(Unpickle(strings.NewReader(input)))
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+}
+
+type taggedInner struct {
+	Host string `pickle:"host"`
+	Port int    `pickle:"port,default=8080"`
+}
+
+type taggedStruct struct {
+	taggedInner `pickle:",inline"`
+	Name        string  `pickle:"name,required"`
+	Secret      string  `pickle:"-"`
+	Count       int     `pickle:"count,string"`
+	Ratio       float64 `pickle:"ratio,string,default=0.5"`
+	Enabled     *bool   `pickle:"enabled,default=true"`
+}
+
+func TestUnpackTagOptions(t *testing.T) {
+	src := map[interface{}]interface{}{
+		"host":   "example.com",
+		"name":   "fizz",
+		"Secret": "hunter2",
+		"count":  "42",
+	}
+
+	var dst taggedStruct
+	u := UnpackInto(&dst)
+	u.AllowMissingFields = false
+	err := u.From(src, nil)
+	if err == nil {
+		t.Fatal("Should have failed for the key of a field tagged -")
+	}
+
+	delete(src, "Secret")
+	err = UnpackInto(&dst).From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Host != "example.com" || dst.Port != 8080 || dst.Name != "fizz" ||
+		dst.Count != 42 || dst.Ratio != 0.5 || dst.Enabled == nil || !*dst.Enabled {
+		t.Fatalf("Got %+v", dst)
+	}
+
+	//Numbers that are not strings are still accepted
+	src["count"] = int64(7)
+	src["port"] = int64(22)
+	err = UnpackInto(&dst).From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Count != 7 || dst.Port != 22 {
+		t.Fatalf("Got %+v", dst)
+	}
+
+	src["count"] = "many"
+	err = UnpackInto(&dst).From(src, nil)
+	if err == nil {
+		t.Fatal("Should have failed to parse the count")
+	}
+
+	delete(src, "count")
+	delete(src, "name")
+	err = UnpackInto(&dst).From(src, nil)
+	if err == nil || !strings.Contains(err.Error(), `Missing required key "name"`) {
+		t.Fatalf("Should have failed for the missing name but got %v", err)
+	}
+}
+
+type EmbeddedInner struct {
+	A int
+	C string `pickle:"c"`
+}
+
+type EmbeddedMiddle struct {
+	EmbeddedInner
+	D int
+}
+
+func TestUnpackUntaggedEmbedded(t *testing.T) {
+	type Outer struct {
+		EmbeddedInner
+		B int
+	}
+
+	//Keys of promoted fields in the outer dict are unpacked
+	var dst Outer
+	u := UnpackInto(&dst)
+	u.AllowMissingFields = false
+	err := u.From(map[interface{}]interface{}{"A": int64(1), "B": int64(2), "c": "x"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Outer{EmbeddedInner: EmbeddedInner{A: 1, C: "x"}, B: 2}
+	if dst != expect {
+		t.Fatalf("Got %+v expected %+v", dst, expect)
+	}
+
+	//A nested dict for the embedded struct still works
+	dst = Outer{}
+	err = UnpackInto(&dst).From(map[interface{}]interface{}{
+		"EmbeddedInner": map[interface{}]interface{}{"A": int64(3)},
+	}, nil)
+	if err != nil || dst.A != 3 {
+		t.Fatalf("Got %+v %v", dst, err)
+	}
+
+	//Fields promoted through several levels are found
+	type Deep struct {
+		EmbeddedMiddle `pickle:",inline"`
+	}
+	var deep Deep
+	err = UnpackInto(&deep).From(map[interface{}]interface{}{"A": int64(4), "D": int64(5)}, nil)
+	if err != nil || deep.A != 4 || deep.D != 5 {
+		t.Fatalf("Got %+v %v", deep, err)
+	}
+}
+
+type pathItem struct {
+	Price int8
+}
+
+type pathOrder struct {
+	Items map[string]pathItem `pickle:"items"`
+}
+
+type pathDocument struct {
+	Orders []pathOrder `pickle:"orders"`
+	Total  uint
+}
+
+func pathSource() map[interface{}]interface{} {
+	item := func(price interface{}) map[interface{}]interface{} {
+		return map[interface{}]interface{}{"Price": price}
+	}
+	orders := make([]interface{}, 4)
+	for i := range orders {
+		orders[i] = map[interface{}]interface{}{
+			"items": map[interface{}]interface{}{"sku": item(int64(i))},
+		}
+	}
+	orders[1].(map[interface{}]interface{})["items"] = map[interface{}]interface{}{"sku": item("free")}
+	orders[3].(map[interface{}]interface{})["items"] = map[interface{}]interface{}{"sku": item(int64(1000))}
+
+	return map[interface{}]interface{}{
+		"orders": orders,
+		"Total":  int64(-1),
+	}
+}
+
+func TestUnpackingErrorPath(t *testing.T) {
+	var dst pathDocument
+	err := UnpackInto(&dst).From(pathSource(), nil)
+	ue, ok := err.(UnpackingError)
+	if !ok {
+		t.Fatalf("Expected an UnpackingError but got %v", err)
+	}
+
+	//Map iteration order decides which error is first
+	switch ue.Path {
+	case `orders[1].items["sku"].Price`:
+		if ue.Err != ErrTargetTypeMismatch {
+			t.Fatalf("Got wrong error %v", ue)
+		}
+	case `orders[3].items["sku"].Price`, "Total":
+		if !errors.Is(ue, ErrTargetTypeOverflow) {
+			t.Fatalf("Got wrong error %v", ue)
+		}
+	default:
+		t.Fatalf("Got wrong path %q", ue.Path)
+	}
+	if !strings.Contains(ue.Error(), " at "+ue.Path+" into ") {
+		t.Fatalf("Error does not include path: %v", ue)
+	}
+}
+
+func TestUnpackCollectErrors(t *testing.T) {
+	var dst pathDocument
+	u := UnpackInto(&dst)
+	u.CollectErrors = true
+	err := u.From(pathSource(), nil)
+	ues, ok := err.(UnpackingErrors)
 	if !ok {
-		t.Fatal("value is not a reader")
+		t.Fatalf("Expected UnpackingErrors but got %v", err)
+	}
+
+	paths := make(map[string]bool)
+	for _, ue := range ues {
+		paths[ue.Path] = true
+	}
+	expect := map[string]bool{
+		`orders[1].items["sku"].Price`: true,
+		`orders[3].items["sku"].Price`: true,
+		"Total":                        true,
+	}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("Got paths %v", paths)
+	}
+
+	//Everything else is unpacked and the failed fields are zero
+	if len(dst.Orders) != 4 || dst.Orders[2].Items["sku"].Price != 2 || dst.Orders[3].Items["sku"].Price != 0 {
+		t.Fatalf("Got %v", dst)
+	}
+}
+
+func TestUnpackOnMismatch(t *testing.T) {
+	var mismatches []string
+	var dst pathDocument
+	u := UnpackInto(&dst)
+	u.AllowMismatchedFields = true
+	u.OnMismatch = func(err UnpackingError) {
+		mismatches = append(mismatches, err.Path)
+	}
+	err := u.From(pathSource(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(mismatches)
+	expect := []string{"Total", `orders[1].items["sku"].Price`, `orders[3].items["sku"].Price`}
+	if !reflect.DeepEqual(mismatches, expect) {
+		t.Fatalf("Got %v expected %v", mismatches, expect)
+	}
+}
+
+type remainRecord struct {
+	ID    int64                  `pickle:"id"`
+	Name  string                 `pickle:"name"`
+	Extra map[string]interface{} `pickle:",remain"`
+}
+
+func TestUnpackRemain(t *testing.T) {
+	src := map[interface{}]interface{}{
+		"id":      int64(7),
+		"name":    "fizz",
+		"color":   "green",
+		"tags":    []interface{}{"a", "b"},
+		"visible": true,
 	}
 
+	var dst remainRecord
+	u := UnpackInto(&dst)
+	u.AllowMissingFields = false
+	err := u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"color":   "green",
+		"tags":    []interface{}{"a", "b"},
+		"visible": true,
+	}
+	if dst.ID != 7 || dst.Name != "fizz" || !reflect.DeepEqual(dst.Extra, expect) {
+		t.Fatalf("Got %+v", dst)
+	}
+
+	//The extra keys are pickled again
 	buf := &bytes.Buffer{}
-	_, err = io.Copy(buf, valReader)
+	_, err = NewPickler(buf).Pickle(dst)
 	if err != nil {
 		t.Fatal(err)
 	}
+	sanityCheck(buf, t, src)
 
-	if buf.String() != magic {
-		t.Fatal("did not get magic value back")
+	var typed struct {
+		ID    int64            `pickle:"id"`
+		Extra map[string]int64 `pickle:",remain"`
+	}
+	err = UnpackInto(&typed).From(map[interface{}]interface{}{"id": int64(1), "n": int64(2)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed.ID != 1 || !reflect.DeepEqual(typed.Extra, map[string]int64{"n": 2}) {
+		t.Fatalf("Got %+v", typed)
+	}
+
+	err = UnpackInto(&typed).From(map[interface{}]interface{}{"n": "two"}, nil)
+	ue, ok := err.(UnpackingError)
+	if !ok || ue.Path != "n" {
+		t.Fatalf("Expected an UnpackingError for n but got %v", err)
+	}
+}
+
+func TestUnpackSets(t *testing.T) {
+	const input = "\x80\x02}q\x00(X\x03\x00\x00\x00idsq\x01c__builtin__\nset\nq\x02]q\x03(K\x01K\x02K\x03e\x85q\x04Rq\x05X\x05\x00\x00\x00pairsq\x06c__builtin__\nfrozenset\nq\x07]q\x08(K\x01X\x01\x00\x00\x00aq\t\x86q\nK\x02X\x01\x00\x00\x00bq\x0b\x86q\x0ce\x85q\rRq\x0eu."
+
+	type pair struct {
+		N    int
+		Name string
+	}
+	var asMaps struct {
+		IDs   map[int]struct{} `pickle:"ids"`
+		Pairs map[pair]bool    `pickle:"pairs"`
+	}
+	err := UnpackInto(&asMaps).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(asMaps.IDs, map[int]struct{}{1: {}, 2: {}, 3: {}}) ||
+		!reflect.DeepEqual(asMaps.Pairs, map[pair]bool{{1, "a"}: true, {2, "b"}: true}) {
+		t.Fatalf("Got %v", asMaps)
+	}
+
+	var asSlices struct {
+		IDs   []uint8          `pickle:"ids"`
+		Pairs [][2]interface{} `pickle:"pairs"`
+	}
+	err = UnpackInto(&asSlices).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(asSlices.IDs, func(i, j int) bool { return asSlices.IDs[i] < asSlices.IDs[j] })
+	if !reflect.DeepEqual(asSlices.IDs, []uint8{1, 2, 3}) || len(asSlices.Pairs) != 2 {
+		t.Fatalf("Got %v", asSlices)
+	}
+
+	var wrong struct {
+		IDs map[string]struct{} `pickle:"ids"`
+	}
+	err = UnpackInto(&wrong).From(Unpickle(strings.NewReader(input)))
+	if err == nil {
+		t.Fatal("Should have failed")
+	}
+}
+
+func TestUnpackNormalize(t *testing.T) {
+	const input = "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01}q\x02X\x01\x00\x00\x00bq\x03]q\x04(]q\x05K\x01a]q\x06K\x02aesX\x01\x00\x00\x00nq\x07\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@X\x01\x00\x00\x00mq\x08\x8a\x08\x00\x00\x00\x00\x00\x00\x00@X\x01\x00\x00\x00tq\t}q\nK\x01K\x02\x86q\x0bX\x01\x00\x00\x00pq\x0csu."
+
+	type Document struct {
+		A map[string]interface{}
+		M interface{}
+		T interface{}
+	}
+
+	var dst Document
+	u := UnpackInto(&dst)
+	u.Normalize = true
+	err := u.From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Document{
+		A: map[string]interface{}{
+			"b": []interface{}{[]interface{}{int64(1)}, []interface{}{int64(2)}},
+		},
+		M: int64(1 << 62),
+		T: map[interface{}]interface{}{
+			[2]interface{}{int64(1), int64(2)}: "p",
+		},
+	}
+	if !reflect.DeepEqual(expect, dst) {
+		t.Fatalf("Got %v expected %v", dst, expect)
+	}
+
+	var tree map[interface{}]interface{}
+	u = UnpackInto(&tree)
+	u.Normalize = true
+	err = u.From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree["a"].(map[string]interface{}); !ok {
+		t.Fatalf("Nested dict was not normalized:%T", tree["a"])
+	}
+
+	//Without normalizing the raw values are kept
+	var raw interface{}
+	err = UnpackInto(&raw).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw.(map[interface{}]interface{}); !ok {
+		t.Fatalf("Got %T", raw)
+	}
+}
+
+func TestUnpackCoerce(t *testing.T) {
+	type Numbers struct {
+		I  int
+		U  uint8
+		F  float64
+		F3 float32
+		B  bool
+		S  string
+		N  big.Int
+	}
+
+	input := map[interface{}]interface{}{
+		"I":  3.0,
+		"U":  true,
+		"F":  int64(7),
+		"F3": "1.5",
+		"B":  int64(1),
+		"S":  2.5,
+		"N":  "123456789012345678901234567890",
+	}
+
+	var dst Numbers
+	u := UnpackInto(&dst)
+	u.Coerce = CoerceAll
+	err := u.From(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	expect := Numbers{I: 3, U: 1, F: 7, F3: 1.5, B: true, S: "2.5", N: *n}
+	if !reflect.DeepEqual(expect, dst) {
+		t.Fatalf("Got %v expected %v", dst, expect)
+	}
+
+	//Without coercions the kinds must match
+	err = UnpackInto(&dst).From(input, nil)
+	if !errors.Is(err, ErrTargetTypeMismatch) {
+		t.Fatalf("Got %v", err)
+	}
+
+	overflows := []struct {
+		src    interface{}
+		dst    interface{}
+		coerce Coercion
+	}{
+		{3.5, new(int), CoerceIntFloat},
+		{1e300, new(int64), CoerceIntFloat},
+		{int64(1<<53 + 1), new(float64), CoerceIntFloat},
+		{int64(1<<24 + 1), new(float32), CoerceIntFloat},
+		{int64(2), new(bool), CoerceBoolInt},
+		{"300", new(uint8), CoerceStringNumber},
+		{"99999999999999999999", new(int64), CoerceStringNumber},
+		{"3.5", new(int), CoerceStringNumber | CoerceIntFloat},
+	}
+
+	for i, o := range overflows {
+		u := UnpackInto(o.dst)
+		u.Coerce = o.coerce
+		err := u.From(o.src, nil)
+		if !errors.Is(err, ErrTargetTypeOverflow) {
+			t.Fatalf("%d: Got %v", i, err)
+		}
+	}
+
+	//A field that overflows is zeroed like any other mismatch
+	var mismatched struct {
+		I int
+	}
+	mismatched.I = 5
+	u = UnpackInto(&mismatched)
+	u.Coerce = CoerceIntFloat
+	u.AllowMismatchedFields = true
+	err = u.From(map[interface{}]interface{}{"I": 3.5}, nil)
+	if err != nil || mismatched.I != 0 {
+		t.Fatalf("Got %d %v", mismatched.I, err)
+	}
+
+	//Each coercion is only made if it is set
+	var i int
+	u = UnpackInto(&i)
+	u.Coerce = CoerceStringNumber
+	err = u.From("3.0", nil)
+	if !errors.Is(err, ErrTargetTypeMismatch) {
+		t.Fatalf("Got %v", err)
+	}
+	u.Coerce |= CoerceIntFloat
+	err = u.From("3.0", nil)
+	if err != nil || i != 3 {
+		t.Fatalf("Got %d %v", i, err)
+	}
+}
+
+func TestUnpackBinary(t *testing.T) {
+	type Thumbnail struct {
+		Raw  []byte
+		Hash [4]byte
+		R    io.Reader
+		Buf  bytes.Buffer
+		W    io.Writer
+	}
+
+	const input = "\x80\x02}(U\x03RawU\x03\x01\x02\x03U\x04HashU\x04\xab\xcd\xef\x01U\x01RU\x02hiU\x03BufU\x02okU\x01WU\x03outu."
+
+	var dst Thumbnail
+	err := UnpackInto(&dst).From(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dst.Raw, []byte{1, 2, 3}) {
+		t.Fatalf("Got %v", dst.Raw)
+	}
+	if dst.Hash != [4]byte{0xab, 0xcd, 0xef, 0x01} {
+		t.Fatalf("Got %v", dst.Hash)
+	}
+	raw, err := ioutil.ReadAll(dst.R)
+	if err != nil || string(raw) != "hi" {
+		t.Fatalf("Got %q %v", raw, err)
+	}
+	if dst.Buf.String() != "ok" {
+		t.Fatalf("Got %q", dst.Buf.String())
+	}
+	//A nil writer is replaced with a buffer
+	if buf, ok := dst.W.(*bytes.Buffer); !ok || buf.String() != "out" {
+		t.Fatalf("Got %v", dst.W)
+	}
+
+	sources := []func() interface{}{
+		func() interface{} { return PickleBytes("xyz") },
+		func() interface{} { return PickleByteArray("xyz") },
+		func() interface{} { return strings.NewReader("xyz") },
+	}
+	for _, source := range sources {
+		src := source()
+		var b []byte
+		err = UnpackInto(&b).From(src, nil)
+		if err != nil || string(b) != "xyz" {
+			t.Fatalf("Got %q %v from %T", b, err, src)
+		}
+
+		var a [3]byte
+		err = UnpackInto(&a).From(source(), nil)
+		if err != nil || string(a[:]) != "xyz" {
+			t.Fatalf("Got %q %v from %T", a, err, src)
+		}
+	}
+
+	var short [2]byte
+	err = UnpackInto(&short).From("xyz", nil)
+	if !errors.Is(err, ErrTargetTypeOverflow) {
+		t.Fatalf("Got %v", err)
+	}
+
+	//A source that is too short is not padded
+	var long [4]byte
+	err = UnpackInto(&long).From("xyz", nil)
+	if !errors.Is(err, ErrTargetTypeMismatch) {
+		t.Fatalf("Got %v", err)
+	}
+
+	//A bytearray is copied, even into a []byte
+	array := PickleByteArray("abc")
+	var copied []byte
+	err = UnpackInto(&copied).From(array, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	array[0] = 'x'
+	if string(copied) != "abc" {
+		t.Fatalf("Got %q", copied)
+	}
+}
+
+func TestUnpackStreamIntoWriters(t *testing.T) {
+	type Image struct {
+		Name string
+		Data io.Writer
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("header:")
+	dst := Image{Data: out}
+
+	src := map[interface{}]interface{}{
+		"Name": "thumb.png",
+		"Data": PickleBytes("\x89PNG"),
+	}
+
+	u := UnpackInto(&dst)
+	u.StreamIntoWriters = true
+	err := u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Data != out || out.String() != "header:\x89PNG" {
+		t.Fatalf("Got %q", out.String())
+	}
+
+	//Readers are copied into the writer
+	src["Data"] = strings.NewReader("more")
+	err = u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
 	}
+	if out.String() != "header:\x89PNGmore" {
+		t.Fatalf("Got %q", out.String())
+	}
+
+	//Without the option the writer is replaced
+	dst.Data = out
+	err = UnpackInto(&dst).From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Data == out {
+		t.Fatal("Writer was not replaced")
+	}
+}
+
+func TestUnpackNullTypes(t *testing.T) {
+	type Row struct {
+		Name    sql.NullString
+		Count   sql.NullInt64
+		Ratio   sql.NullFloat64
+		Ok      sql.NullBool
+		Missing sql.NullString
+		Big     sql.NullInt64
+	}
+
+	dst := Row{Missing: sql.NullString{String: "x", Valid: true}}
+	err := UnpackInto(&dst).From(map[interface{}]interface{}{
+		"Name":    PickleBytes("abc"),
+		"Count":   int64(7),
+		"Ratio":   PickleNone{},
+		"Ok":      true,
+		"Missing": PickleNone{},
+		"Big":     big.NewInt(42),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Row{
+		Name:  sql.NullString{String: "abc", Valid: true},
+		Count: sql.NullInt64{Int64: 7, Valid: true},
+		Ok:    sql.NullBool{Bool: true, Valid: true},
+		Big:   sql.NullInt64{Int64: 42, Valid: true},
+	}
+	if !reflect.DeepEqual(expect, dst) {
+		t.Fatalf("Got %v expected %v", dst, expect)
+	}
+
+	//An error from Scan is a mismatch
+	err = UnpackInto(&dst).From(map[interface{}]interface{}{"Count": "abc"}, nil)
+	if !errors.Is(err, ErrTargetTypeMismatch) {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+type scanList []string
+
+func (l *scanList) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok {
+		return errors.New("not a string")
+	}
+	*l = strings.Split(s, ",")
+	return nil
 }
 
+type scanPoint struct {
+	X, Y int
+}
+
+func (p *scanPoint) Scan(src interface{}) error {
+	return errors.New("not supported")
+}
+
+func TestUnpackScannerContainers(t *testing.T) {
+	//Lists and dicts fill types with a Scan method by the usual rules
+	var dst struct {
+		L scanList
+		P scanPoint
+		S scanList
+	}
+	err := UnpackInto(&dst).From(map[interface{}]interface{}{
+		"L": []interface{}{"a", "b"},
+		"P": map[interface{}]interface{}{"X": int64(1), "Y": int64(2)},
+		"S": "c,d",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(dst.L, scanList{"a", "b"}) {
+		t.Fatalf("Got %v", dst.L)
+	}
+	if dst.P != (scanPoint{X: 1, Y: 2}) {
+		t.Fatalf("Got %v", dst.P)
+	}
+	if !reflect.DeepEqual(dst.S, scanList{"c", "d"}) {
+		t.Fatalf("Got %v", dst.S)
+	}
+}
+
+func TestUnpackZeroOnNone(t *testing.T) {
+	type Plain struct {
+		Name  string
+		Count int
+		Ptr   *int
+	}
 
+	one := 1
+	dst := Plain{Name: "abc", Count: 7, Ptr: &one}
+	src := map[interface{}]interface{}{
+		"Name":  PickleNone{},
+		"Count": PickleNone{},
+		"Ptr":   PickleNone{},
+	}
+
+	err := UnpackInto(&dst).From(src, nil)
+	if !errors.Is(err, ErrTargetTypeNotPointer) {
+		t.Fatalf("Got %v", err)
+	}
 
- 
\ No newline at end of file
+	dst = Plain{Name: "abc", Count: 7, Ptr: &one}
+	u := UnpackInto(&dst)
+	u.ZeroOnNone = true
+	err = u.From(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Plain{}, dst) {
+		t.Fatalf("Got %v", dst)
+	}
+}
@@ -0,0 +1,168 @@
+package stalecucumber
+
+import (
+	"bytes"
+	"io"
+)
+
+/*
+This type is used to unpickle data with options that Unpickle does not
+offer. Unpicklers are created by calling NewUnpickler. Each call to
+Unpickle reads a complete pickle program from R.
+
+Its safe to assign R to other values in between calls to Unpickle.
+
+Resolver is used to resolve Python globals. If it is nil
+PythonBuiltinResolver is used, the same as Unpickle.
+
+If Visitor is not nil it is called with each opcode before the opcode
+executes. See OpcodeVisitor.
+
+Encoding and Errors control how Python 2 strings are converted. They
+have the same meaning as the arguments of the same name to "pickle.load"
+in Python 3. If Encoding is empty the bytes of each string are placed in
+a Go string unchanged, which is the same as Unpickle. Otherwise it is one
+of "ascii", "latin-1", "utf-8" or "cp1252" and each string is decoded into
+UTF-8. Errors is one of "strict", "replace" or "ignore". Under "strict",
+which is the default, bytes that can not be decoded fail with a
+StringDecodingError. "replace" substitutes U+FFFD and "ignore" drops
+them. If Encoding is "bytes" each string is returned as PickleBytes, so
+that it can be told apart from Python unicode.
+
+If FixImports is set the module and name of each Python global written by
+Python 2 are changed to their Python 3 equivalents before they are passed
+to the Resolver. For example "__builtin__" becomes "builtins" and
+"copy_reg" becomes "copyreg". This is the same as "fix_imports" in Python
+3 and allows a single resolver to handle pickles from either version.
+
+NumberMode selects the Go types of Python numbers. See NumberMode.
+
+If FindClass is not nil it is called with the module and name of each
+Python global as soon as it is read, after FixImports is applied. This is
+the equivalent of overriding "find_class" in Python. It returns the value
+used in place of the global, or an error to reject the pickle. Only a
+PythonGlobal can be called by REDUCE or INST, so to allow a global to
+reach the Resolver return it as a PythonGlobal, possibly renamed. When
+FindClass is nil every global is a PythonGlobal.
+
+If Builder is not nil it creates every list, dict, tuple and set in the
+result. See ContainerBuilder.
+*/
+type Unpickler struct {
+	R          io.Reader
+	Resolver   PythonResolver
+	Visitor    OpcodeVisitor
+	Encoding   string
+	Errors     string
+	FixImports bool
+	NumberMode NumberMode
+	FindClass  func(module string, name string) (interface{}, error)
+	Builder    ContainerBuilder
+}
+
+/*
+Receives each opcode of a pickle program as it is unpickled. The
+argument of the opcode is decoded the same way Disassembler does.
+StackDepth is the number of items on the stack of the pickle machine
+before the opcode executes.
+
+If VisitOpcode returns an error unpickling stops without executing the
+opcode. The error is returned from Unpickle as the cause of a
+PickleMachineError.
+*/
+type OpcodeVisitor interface {
+	VisitOpcode(ins Instruction) error
+}
+
+/*
+Allows an ordinary function to be used as an OpcodeVisitor.
+*/
+type OpcodeVisitorFunc func(ins Instruction) error
+
+func (f OpcodeVisitorFunc) VisitOpcode(ins Instruction) error {
+	return f(ins)
+}
+
+func NewUnpickler(reader io.Reader) *Unpickler {
+	retval := &Unpickler{}
+	retval.R = reader
+	return retval
+}
+
+func (u *Unpickler) Unpickle() (interface{}, error) {
+	var pm PickleMachine
+	pm.buf = &bytes.Buffer{}
+	pm.input = &countingReader{r: u.R}
+	pm.Reader = pm.input
+	pm.lastMark = -1
+	if u.Resolver == nil {
+		pm.resolver = PythonBuiltinResolver{}
+	} else {
+		pm.resolver = u.Resolver
+	}
+	if len(u.Encoding) != 0 {
+		decoding, err := newStringDecoder(u.Encoding, u.Errors)
+		if err != nil {
+			return nil, err
+		}
+		pm.decoding = decoding
+	}
+	pm.fixImports = u.FixImports
+	pm.numbers = u.NumberMode
+	pm.classFinder = u.FindClass
+	pm.builder = u.Builder
+	if u.Visitor != nil {
+		pm.visitor = u.Visitor
+		//The visitor must not reject any pickle that
+		//executes, so only the machine checks the stack
+		pm.decoder = newCapturingDisassembler(pm.input)
+		pm.decoder.lenient = true
+	}
+	//Pre allocate a small stack
+	pm.Stack = make([]interface{}, 0, 16)
+
+	err := (&pm).execute()
+	if err != ErrOpcodeStopped {
+		return nil, pm.error(err)
+	}
+
+	if len(pm.Stack) == 0 {
+		return nil, ErrNoResult
+	}
+
+	if pm.builder == nil {
+		return pm.Stack[0], nil
+	}
+
+	result, err := pm.embed(pm.Stack[0])
+	if err != nil {
+		return nil, err
+	}
+	return pm.builder.Finish(result)
+}
+
+/*
+Decodes the next opcode, passes it to the visitor and then executes it
+with its argument read back from the decoded bytes.
+*/
+func (pm *PickleMachine) visitOpcode() error {
+	ins, err := pm.decoder.Next()
+	pm.opcodeOffset = ins.Offset
+	pm.currentOpcode = ins.Opcode
+	if err != nil {
+		if de, ok := err.(DisassemblyError); ok {
+			return de.Err
+		}
+		return err
+	}
+
+	ins.StackDepth = len(pm.Stack)
+	err = pm.visitor.VisitOpcode(ins)
+	if err != nil {
+		return err
+	}
+
+	pm.argument.Reset(ins.raw[1:])
+	pm.Reader = &pm.argument
+	return jumpList[int(ins.Opcode)](pm)
+}
@@ -0,0 +1,166 @@
+package stalecucumber
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnpicklerVisitor(t *testing.T) {
+	const input = "\x80\x02]q\x00(c__builtin__\nset\nq\x01]q\x02K\x01a\x85q\x03Rq\x04h\x02X\x02\x00\x00\x00abq\x05e."
+
+	var names []string
+	var depths []int
+	var globals []interface{}
+	var memoHits int
+	u := NewUnpickler(strings.NewReader(input))
+	u.Visitor = OpcodeVisitorFunc(func(ins Instruction) error {
+		names = append(names, ins.Name)
+		depths = append(depths, ins.StackDepth)
+		switch ins.Opcode {
+		case OPCODE_GLOBAL:
+			globals = append(globals, ins.Arg)
+		case OPCODE_BINGET:
+			memoHits++
+		}
+		return nil
+	})
+
+	v, err := u.Unpickle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Fatalf("Got %v expected %v", v, expect)
+	}
+
+	expectNames := []string{"PROTO", "EMPTY_LIST", "BINPUT", "MARK", "GLOBAL", "BINPUT",
+		"EMPTY_LIST", "BINPUT", "BININT1", "APPEND", "TUPLE1", "BINPUT", "REDUCE",
+		"BINPUT", "BINGET", "BINUNICODE", "BINPUT", "APPENDS", "STOP"}
+	if !reflect.DeepEqual(names, expectNames) {
+		t.Fatalf("Got opcodes %v expected %v", names, expectNames)
+	}
+	expectDepths := []int{0, 0, 1, 1, 2, 3, 3, 4, 4, 5, 4, 4, 4, 3, 3, 4, 5, 5, 1}
+	if !reflect.DeepEqual(depths, expectDepths) {
+		t.Fatalf("Got stack depths %v expected %v", depths, expectDepths)
+	}
+	if !reflect.DeepEqual(globals, []interface{}{"__builtin__ set"}) || memoHits != 1 {
+		t.Fatalf("Got globals %v and %d memo hits", globals, memoHits)
+	}
+}
+
+func TestUnpicklerVisitorPopMark(t *testing.T) {
+	//A visitor accepts the same pickles as Unpickle, including
+	//a POP of a mark from a tuple that contains itself
+	const input = "((lp0\n(g0\ntp1\na00g1\n."
+
+	var pops int
+	u := NewUnpickler(strings.NewReader(input))
+	u.Visitor = OpcodeVisitorFunc(func(ins Instruction) error {
+		if ins.Opcode == OPCODE_POP {
+			pops++
+		}
+		return nil
+	})
+
+	v, err := u.Unpickle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect, err := Unpickle(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, expect) || pops != 2 {
+		t.Fatalf("Got %v with %d pops expected %v", v, pops, expect)
+	}
+}
+
+func TestUnpicklerVisitorAbort(t *testing.T) {
+	errAbort := errors.New("abort")
+	executed := 0
+	u := NewUnpickler(strings.NewReader("(lp0\nI1\naI2\na."))
+	u.Visitor = OpcodeVisitorFunc(func(ins Instruction) error {
+		if ins.Name == "APPEND" {
+			return errAbort
+		}
+		executed++
+		return nil
+	})
+
+	_, err := u.Unpickle()
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Expected abort but got %v", err)
+	}
+	pme := err.(PickleMachineError)
+	if pme.Offset != 8 || pme.OpcodeName != "APPEND" || pme.StackSize != 2 || executed != 4 {
+		t.Fatalf("Got wrong error %v after %d opcodes", pme, executed)
+	}
+}
+
+func TestUnpicklerVisitorMalformed(t *testing.T) {
+	u := NewUnpickler(strings.NewReader("(lp0\nI1\na"))
+	u.Visitor = OpcodeVisitorFunc(func(ins Instruction) error {
+		return nil
+	})
+
+	_, err := u.Unpickle()
+	if !errors.Is(err, ErrInputTruncated) {
+		t.Fatalf("Expected truncated input but got %v", err)
+	}
+}
+
+func TestUnpicklerFindClass(t *testing.T) {
+	//{'cls': collections.OrderedDict, 'set': set([1])}
+	const input = "\x80\x02}q\x00(X\x03\x00\x00\x00clsq\x01ccollections\nOrderedDict\nq\x02X\x03\x00\x00\x00setq\x03c__builtin__\nset\nq\x04]q\x05K\x01a\x85q\x06Rq\x07u."
+
+	v, err := Dict(Unpickle(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["cls"] != (PythonGlobal{Module: "collections", Name: "OrderedDict"}) {
+		t.Fatalf("Got (%T)%v", v["cls"], v["cls"])
+	}
+
+	var found []PythonGlobal
+	u := NewUnpickler(strings.NewReader(input))
+	u.FixImports = true
+	u.FindClass = func(module string, name string) (interface{}, error) {
+		found = append(found, PythonGlobal{module, name})
+		if module == "collections" {
+			return "replaced", nil
+		}
+		return PythonGlobal{module, name}, nil
+	}
+	v, err = Dict(u.Unpickle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["cls"] != "replaced" {
+		t.Fatalf("Got (%T)%v", v["cls"], v["cls"])
+	}
+	if _, err := Set(v["set"], nil); err != nil {
+		t.Fatal(err)
+	}
+	expect := []PythonGlobal{{"collections", "OrderedDict"}, {"builtins", "set"}}
+	if !reflect.DeepEqual(found, expect) {
+		t.Fatalf("Got %v expected %v", found, expect)
+	}
+
+	errRejected := errors.New("rejected")
+	u = NewUnpickler(strings.NewReader(input))
+	u.FindClass = func(module string, name string) (interface{}, error) {
+		return nil, errRejected
+	}
+	_, err = u.Unpickle()
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("Expected rejection but got %v", err)
+	}
+}